@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Attestations are pushed as OCI artifacts referring to the builder index (referrers),
+// registries without the referrers API get them listed under the "sha256-<hex>" fallback tag.
+const (
+	cycloneDXMediaType = "application/vnd.cyclonedx+json"
+	inTotoMediaType    = "application/vnd.in-toto+json"
+	// config of OCI 1.1 artifacts
+	emptyConfigMediaType types.MediaType = "application/vnd.oci.empty.v1+json"
+
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	slsaProvenanceType  = "https://slsa.dev/provenance/v1"
+	buildType           = "https://github.com/gauron99/actions-testing/cmd/update-builder@v1"
+)
+
+var emptyConfig = []byte("{}")
+
+// builderInputs describes what a published builder was built from.
+type builderInputs struct {
+	distro  string
+	variant string
+	// nil for the default builder
+	profile *buildProfile
+	// upstream builder release and its source tarball
+	release    string
+	tarballURL string
+	// resolved versions of injected buildpacks (ID -> version)
+	injected map[string]string
+	// stack images the builder was built with
+	buildImage string
+	runImage   string
+	// labels of the built builder, same for all arches
+	labels builderLabels
+}
+
+type cycloneDXComponent struct {
+	Type    string `json:"type"`
+	BOMRef  string `json:"bom-ref,omitempty"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// homepage of buildpacks
+	ExternalReferences []cycloneDXReference `json:"externalReferences,omitempty"`
+}
+
+type cycloneDXReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type cycloneDX struct {
+	BOMFormat   string `json:"bomFormat"`
+	SpecVersion string `json:"specVersion"`
+	Version     int    `json:"version"`
+	Metadata    struct {
+		Timestamp string             `json:"timestamp"`
+		Component cycloneDXComponent `json:"component"`
+	} `json:"metadata"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaResourceDescriptor struct {
+	URI    string            `json:"uri"`
+	Name   string            `json:"name,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type slsaProvenance struct {
+	BuildDefinition struct {
+		BuildType            string                   `json:"buildType"`
+		ExternalParameters   map[string]any           `json:"externalParameters"`
+		ResolvedDependencies []slsaResourceDescriptor `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Metadata struct {
+			InvocationID string `json:"invocationId,omitempty"`
+			StartedOn    string `json:"startedOn"`
+			FinishedOn   string `json:"finishedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     slsaProvenance  `json:"predicate"`
+}
+
+// returns CycloneDX SBOM listing all buildpacks (nested ones included), the lifecycle and the stack images of the builder
+func newSBOM(in builderInputs, repo name.Repository, now time.Time) cycloneDX {
+	var bom cycloneDX
+	bom.BOMFormat = "CycloneDX"
+	bom.SpecVersion = "1.5"
+	bom.Version = 1
+	bom.Metadata.Timestamp = now.UTC().Format(time.RFC3339)
+	bom.Metadata.Component = cycloneDXComponent{
+		Type:    "container",
+		Name:    repo.Name(),
+		Version: in.release + in.profile.tagSuffix(),
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(in.labels.Layers)) {
+		for _, version := range slices.Sorted(maps.Keys(in.labels.Layers[id])) {
+			c := cycloneDXComponent{
+				Type:    "application",
+				BOMRef:  "buildpack:" + id + "@" + version,
+				Name:    id,
+				Version: version,
+			}
+			if hp := in.labels.Layers[id][version].Homepage; hp != "" {
+				c.ExternalReferences = []cycloneDXReference{{Type: "website", URL: hp}}
+			}
+			bom.Components = append(bom.Components, c)
+		}
+	}
+	if v := in.labels.Metadata.Lifecycle.Version; v != "" {
+		bom.Components = append(bom.Components, cycloneDXComponent{
+			Type:    "application",
+			BOMRef:  "lifecycle@" + v,
+			Name:    "buildpacksio/lifecycle",
+			Version: v,
+		})
+	}
+	for _, img := range []string{in.buildImage, in.runImage} {
+		if img == "" {
+			continue
+		}
+		c := cycloneDXComponent{
+			Type:   "container",
+			BOMRef: "image:" + img,
+			Name:   img,
+		}
+		if ref, err := name.ParseReference(img); err == nil {
+			c.Name, c.Version = ref.Context().Name(), ref.Identifier()
+		}
+		bom.Components = append(bom.Components, c)
+	}
+	return bom
+}
+
+// returns SLSA provenance statement of the builder index with given digest
+func newProvenance(in builderInputs, repo name.Repository, digest v1.Hash, started, finished time.Time) inTotoStatement {
+	st := inTotoStatement{
+		Type: inTotoStatementType,
+		Subject: []inTotoSubject{{
+			Name:   repo.Name(),
+			Digest: map[string]string{digest.Algorithm: digest.Hex},
+		}},
+		PredicateType: slsaProvenanceType,
+	}
+	p := &st.Predicate
+	p.BuildDefinition.BuildType = buildType
+	p.BuildDefinition.ExternalParameters = map[string]any{
+		"distribution": in.distro,
+		"variant":      in.variant,
+		"release":      in.release,
+		"buildpacks":   in.injected,
+	}
+	if in.profile != nil {
+		p.BuildDefinition.ExternalParameters["profile"] = in.profile.Name
+	}
+
+	deps := []slsaResourceDescriptor{{URI: in.tarballURL, Name: "upstream builder"}}
+	for _, mod := range in.labels.modules() {
+		deps = append(deps, slsaResourceDescriptor{URI: "urn:buildpack:" + mod, Name: "buildpack"})
+	}
+	for _, img := range []string{in.buildImage, in.runImage} {
+		if img == "" {
+			continue
+		}
+		d := slsaResourceDescriptor{URI: dockerScheme + img, Name: "stack image"}
+		if ref, err := name.NewDigest(img); err == nil {
+			if h, err := v1.NewHash(ref.DigestStr()); err == nil {
+				d.Digest = map[string]string{h.Algorithm: h.Hex}
+			}
+		}
+		deps = append(deps, d)
+	}
+	p.BuildDefinition.ResolvedDependencies = deps
+
+	p.RunDetails.Builder.ID = buildType
+	if server, repo, run := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID"); server != "" && repo != "" && run != "" {
+		p.RunDetails.Builder.ID = server + "/" + repo + "/actions/runs/" + run
+		p.RunDetails.Metadata.InvocationID = p.RunDetails.Builder.ID + "/attempts/" + os.Getenv("GITHUB_RUN_ATTEMPT")
+	}
+	p.RunDetails.Metadata.StartedOn = started.UTC().Format(time.RFC3339)
+	p.RunDetails.Metadata.FinishedOn = finished.UTC().Format(time.RFC3339)
+	return st
+}
+
+// pushes SBOM and provenance of the index to the repository of the index, as artifacts referring to it
+func attachAttestations(in builderInputs, repo name.Repository, idx v1.ImageIndex, started time.Time, remoteOpts ...remote.Option) error {
+	subject, err := partial.Descriptor(idx)
+	if err != nil {
+		return fmt.Errorf("cannot get descriptor of the index: %w", err)
+	}
+	now := time.Now()
+
+	sbom, err := json.Marshal(newSBOM(in, repo, now))
+	if err != nil {
+		return fmt.Errorf("cannot marshal SBOM: %w", err)
+	}
+	err = attachArtifact(repo, *subject, cycloneDXMediaType, sbom, remoteOpts...)
+	if err != nil {
+		return fmt.Errorf("cannot attach SBOM: %w", err)
+	}
+
+	provenance, err := json.Marshal(newProvenance(in, repo, subject.Digest, started, now))
+	if err != nil {
+		return fmt.Errorf("cannot marshal provenance: %w", err)
+	}
+	err = attachArtifact(repo, *subject, inTotoMediaType, provenance, remoteOpts...)
+	if err != nil {
+		return fmt.Errorf("cannot attach provenance: %w", err)
+	}
+	return nil
+}
+
+// pushes artifact of given type with payload as its only layer, referring to subject
+func attachArtifact(repo name.Repository, subject v1.Descriptor, artifactType string, payload []byte, remoteOpts ...remote.Option) error {
+	img, err := newArtifact(subject, artifactType, payload)
+	if err != nil {
+		return fmt.Errorf("cannot create artifact: %w", err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return fmt.Errorf("cannot get digest of the artifact: %w", err)
+	}
+	ref := repo.Digest(digest.String())
+	err = remote.Write(ref, img, remoteOpts...)
+	if err != nil {
+		return fmt.Errorf("cannot push artifact: %w", err)
+	}
+	err = fixFallbackArtifactType(repo, subject, digest, artifactType, remoteOpts...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("## attached: '%v' -> '%v@%v'\n", artifactType, repo.Name(), subject.Digest)
+	return nil
+}
+
+// go-containerregistry lists artifacts under the fallback tag of registries without the referrers API
+// with the media type of their config as the artifact type, sets the artifact type from the manifest instead
+func fixFallbackArtifactType(repo name.Repository, subject v1.Descriptor, digest v1.Hash, artifactType string, remoteOpts ...remote.Option) error {
+	tag := repo.Tag(subject.Digest.Algorithm + "-" + subject.Digest.Hex)
+	desc, err := remote.Get(tag, remoteOpts...)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("cannot get referrers of the index: %w", err)
+	}
+	var im v1.IndexManifest
+	err = json.Unmarshal(desc.Manifest, &im)
+	if err != nil {
+		return fmt.Errorf("cannot parse referrers of the index: %w", err)
+	}
+	i := slices.IndexFunc(im.Manifests, func(d v1.Descriptor) bool { return d.Digest == digest })
+	if i < 0 || im.Manifests[i].ArtifactType == artifactType {
+		return nil
+	}
+	im.Manifests[i].ArtifactType = artifactType
+	raw, err := json.Marshal(im)
+	if err != nil {
+		return fmt.Errorf("cannot marshal referrers of the index: %w", err)
+	}
+	err = remote.Put(tag, rawManifest{raw: raw, mediaType: types.OCIImageIndex}, remoteOpts...)
+	if err != nil {
+		return fmt.Errorf("cannot update referrers of the index: %w", err)
+	}
+	return nil
+}
+
+// rawManifest is manifest pushed as is by remote.Put
+type rawManifest struct {
+	raw       []byte
+	mediaType types.MediaType
+}
+
+func (m rawManifest) RawManifest() ([]byte, error) {
+	return m.raw, nil
+}
+
+func (m rawManifest) MediaType() (types.MediaType, error) {
+	return m.mediaType, nil
+}
+
+// artifactManifest is OCI 1.1 image manifest of an artifact, v1.Manifest has no artifactType
+type artifactManifest struct {
+	SchemaVersion int64           `json:"schemaVersion"`
+	MediaType     types.MediaType `json:"mediaType"`
+	ArtifactType  string          `json:"artifactType"`
+	Config        v1.Descriptor   `json:"config"`
+	Layers        []v1.Descriptor `json:"layers"`
+	Subject       *v1.Descriptor  `json:"subject,omitempty"`
+}
+
+// artifact is image with the empty config of OCI 1.1 artifacts, see artifactManifest
+type artifact struct {
+	manifest []byte
+	layer    v1.Layer
+}
+
+// returns artifact of given type with payload as its only layer, referring to subject
+func newArtifact(subject v1.Descriptor, artifactType string, payload []byte) (v1.Image, error) {
+	layer := static.NewLayer(payload, types.MediaType(artifactType))
+	layerDesc, err := partial.Descriptor(layer)
+	if err != nil {
+		return nil, err
+	}
+	config := static.NewLayer(emptyConfig, emptyConfigMediaType)
+	configDesc, err := partial.Descriptor(config)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := json.Marshal(artifactManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		ArtifactType:  artifactType,
+		Config:        *configDesc,
+		Layers:        []v1.Descriptor{*layerDesc},
+		Subject:       &subject,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return partial.CompressedToImage(&artifact{manifest: manifest, layer: layer})
+}
+
+func (a *artifact) RawConfigFile() ([]byte, error) {
+	return emptyConfig, nil
+}
+
+func (a *artifact) MediaType() (types.MediaType, error) {
+	return types.OCIManifestSchema1, nil
+}
+
+func (a *artifact) RawManifest() ([]byte, error) {
+	return a.manifest, nil
+}
+
+func (a *artifact) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	digest, err := a.layer.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if h != digest {
+		return nil, fmt.Errorf("unknown layer %v", h)
+	}
+	return a.layer, nil
+}
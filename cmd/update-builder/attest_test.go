@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildpacks/pack/pkg/dist"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestAttachAttestations(t *testing.T) {
+	reg := testRegistry(t)
+
+	idx, err := random.Index(64, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := pushTestIndex(t, reg+"/builder-jammy-base:0.0.1", idx)
+
+	in := builderInputs{
+		distro:     distroJammy,
+		variant:    "base",
+		release:    "0.0.1",
+		tarballURL: "https://api.github.com/repos/paketo-buildpacks/builder-jammy-base/tarball/v0.0.1",
+		injected:   map[string]string{"paketo-community/rust": "0.65.0"},
+		buildImage: "localhost:5000/build-jammy-base:0.1.0",
+		runImage:   "ghcr.io/gauron99/run-jammy-base@sha256:" + strings.Repeat("a", 64),
+		labels: builderLabels{
+			Layers: dist.ModuleLayers{
+				"paketo-buildpacks/java":    {"18.9.0": {}},
+				"paketo-buildpacks/quarkus": {"1.2.3": {Homepage: "https://github.com/paketo-buildpacks/quarkus"}},
+			},
+		},
+	}
+	in.labels.Metadata.Lifecycle.Version = "0.20.0"
+
+	sbom := newSBOM(in, ref.Context(), time.Now())
+	var names []string
+	for _, c := range sbom.Components {
+		names = append(names, c.Name+"@"+c.Version)
+	}
+	for _, want := range []string{
+		"paketo-buildpacks/java@18.9.0",
+		"paketo-buildpacks/quarkus@1.2.3",
+		"buildpacksio/lifecycle@0.20.0",
+		"localhost:5000/build-jammy-base@0.1.0",
+	} {
+		if !slices.Contains(names, want) {
+			t.Errorf("SBOM components %v do not contain %q", names, want)
+		}
+	}
+
+	digest, err := idx.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prov := newProvenance(in, ref.Context(), digest, time.Now(), time.Now())
+	if prov.Subject[0].Digest["sha256"] != digest.Hex {
+		t.Errorf("unexpected subject digest: %v", prov.Subject[0].Digest)
+	}
+	deps := prov.Predicate.BuildDefinition.ResolvedDependencies
+	if last := deps[len(deps)-1]; last.Digest["sha256"] != strings.Repeat("a", 64) {
+		t.Errorf("digest of run image not recorded: %+v", last)
+	}
+
+	err = attachAttestations(in, ref.Context(), idx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	referrers, err := remote.Referrers(ref.Context().Digest(digest.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	im, err := referrers.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, d := range im.Manifests {
+		types = append(types, d.ArtifactType)
+	}
+	slices.Sort(types)
+	if !slices.Equal(types, []string{cycloneDXMediaType, inTotoMediaType}) {
+		t.Errorf("unexpected referrers: %v", types)
+	}
+
+	// artifacts follow OCI 1.1, the type is in the manifest and the config is empty
+	for _, d := range im.Manifests {
+		desc, err := remote.Get(ref.Context().Digest(d.Digest.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var mf artifactManifest
+		if err = json.Unmarshal(desc.Manifest, &mf); err != nil {
+			t.Fatal(err)
+		}
+		if mf.ArtifactType != d.ArtifactType || mf.Config.MediaType != emptyConfigMediaType || mf.Subject == nil || mf.Subject.Digest != digest {
+			t.Errorf("unexpected artifact manifest: %s", desc.Manifest)
+		}
+		img, err := desc.Image()
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := img.RawConfigFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(config) != "{}" {
+			t.Errorf("unexpected artifact config %q", config)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// checkpoint records outputs of the finished stages of the pipeline of each variant,
+// so that a run resumed with the -resume flag skips them. The stages are:
+//
+//   - resolve: upstream release and versions of injected buildpacks
+//   - mirror stack: stack images copied to their mirrors
+//   - package buildpacks: patched and offline buildpacks built into the daemon
+//   - build: builders of each arch pushed to the local registry
+//   - assemble index: digest of the index
+//   - publish: the index promoted to the release tags and notes of its release
+//   - release: the GitHub release created
+//
+// Outputs living in the daemon or the local registry are checked before they are
+// reused, the stage runs again if they are gone, e.g. on a fresh CI runner.
+type checkpoint struct {
+	path string
+	// hash of the configuration, checkpoint of a different configuration cannot be resumed
+	Config   string                        `json:"config"`
+	Variants map[string]*variantCheckpoint `json:"variants"`
+}
+
+type variantCheckpoint struct {
+	// resolve
+	Release    string            `json:"release,omitempty"`
+	TarballURL string            `json:"tarballURL,omitempty"`
+	HTMLURL    string            `json:"htmlURL,omitempty"`
+	Versions   map[string]string `json:"versions,omitempty"`
+	// creation time in labels, kept so that resumed builds assemble the same index
+	Created time.Time `json:"created,omitempty"`
+	// Images pinned to digests and relocated images, shared with digestPinner and relocator
+	// so that resumed builds use the same digests and do not copy images again.
+	Pinned    map[string]string `json:"pinned,omitempty"`
+	Relocated map[string]string `json:"relocated,omitempty"`
+	// mirror stack
+	StackMirrored bool `json:"stackMirrored,omitempty"`
+	// package buildpacks ("<arch> <image>" -> image)
+	Packaged map[string]string `json:"packaged,omitempty"`
+	// build (arch -> image with digest)
+	Builders map[string]string `json:"builders,omitempty"`
+	// assemble index
+	Index string `json:"index,omitempty"`
+	// publish, the index with digest and notes of the release, kept since the diff in them
+	// is to the builder the latest tag pointed to before the index was published
+	Published string        `json:"published,omitempty"`
+	Notes     *releaseNotes `json:"notes,omitempty"`
+	// the index in every destination, the first one is Published
+	Destinations []string `json:"destinations,omitempty"`
+	// release
+	Released bool `json:"released,omitempty"`
+}
+
+// returns new checkpoint saved to path, or the checkpoint saved there by the previous run if resume is set
+func loadCheckpoint(path string, resume bool, cfg *config) (*checkpoint, error) {
+	bs, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal config: %w", err)
+	}
+	h := sha256.Sum256(bs)
+	cp := &checkpoint{
+		path:     path,
+		Config:   hex.EncodeToString(h[:]),
+		Variants: make(map[string]*variantCheckpoint),
+	}
+	if !resume {
+		return cp, nil
+	}
+	if path == "" {
+		return nil, fmt.Errorf("cannot resume without state file")
+	}
+
+	bs, err = os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Println("## no checkpoint to resume, starting over")
+			return cp, nil
+		}
+		return nil, fmt.Errorf("cannot read checkpoint: %w", err)
+	}
+	var saved checkpoint
+	err = json.Unmarshal(bs, &saved)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse checkpoint: %w", err)
+	}
+	if saved.Config != cp.Config {
+		return nil, fmt.Errorf("configuration changed since the checkpoint was saved, run without -resume")
+	}
+	if saved.Variants != nil {
+		cp.Variants = saved.Variants
+	}
+	return cp, nil
+}
+
+func (cp *checkpoint) variant(key string) *variantCheckpoint {
+	vc, ok := cp.Variants[key]
+	if !ok {
+		vc = &variantCheckpoint{}
+		cp.Variants[key] = vc
+	}
+	if vc.Pinned == nil {
+		vc.Pinned = make(map[string]string)
+	}
+	if vc.Relocated == nil {
+		vc.Relocated = make(map[string]string)
+	}
+	if vc.Packaged == nil {
+		vc.Packaged = make(map[string]string)
+	}
+	if vc.Builders == nil {
+		vc.Builders = make(map[string]string)
+	}
+	return vc
+}
+
+// fills result of the variant published by the checkpointed run, the same as the run did
+func (vc *variantCheckpoint) restoreResult(res *variantResult) {
+	res.Release, res.Index, res.Published = vc.Release, vc.Published, vc.Destinations
+	res.Buildpacks = vc.Versions
+	if len(vc.Pinned) > 0 {
+		res.Digests = vc.Pinned
+	}
+	if len(vc.Relocated) > 0 {
+		res.Relocated = vc.Relocated
+	}
+	for arch, img := range vc.Builders {
+		res.arch(arch).Image = img
+	}
+}
+
+// writes the checkpoint to its path, nothing is written if the path is empty
+func (cp *checkpoint) save() error {
+	if cp.path == "" {
+		return nil
+	}
+	bs, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal checkpoint: %w", err)
+	}
+	// written to temporary file first, so that a cancelled run does not leave broken checkpoint
+	tmp, err := os.CreateTemp(filepath.Dir(cp.path), ".checkpoint-")
+	if err != nil {
+		return fmt.Errorf("cannot write checkpoint: %w", err)
+	}
+	defer func(name string) {
+		_ = os.Remove(name)
+	}(tmp.Name())
+	_, err = tmp.Write(append(bs, '\n'))
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cp.path)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// builds the buildpack image unless it was built by the checkpointed run and is still in the daemon,
+// or in the local registry for the daemonless engine
+func (st *variantState) buildBuildpack(ctx context.Context, bp buildpack, arch string) (string, error) {
+	key := arch + " " + bp.image + ":" + bp.version + bp.tagSuffix
+	if img, ok := st.checkpoint.Packaged[key]; ok {
+		imgArch, err := st.engine.imageArch(ctx, img, registryOptions(ctx)...)
+		if err == nil && imgArch == arch {
+			fmt.Printf("## reusing packaged buildpack: '%v'\n", img)
+			st.daemonImages.add(img)
+			return img, nil
+		}
+	}
+	img, err := buildBuildpackImage(ctx, st.ghClient, st.engine, bp, arch)
+	if err != nil {
+		return "", err
+	}
+	st.daemonImages.add(img)
+	st.checkpoint.Packaged[key] = img
+	return img, st.saveCheckpoint()
+}
+
+// returns builder of the arch pushed to the local registry by the checkpointed run, "" if there is none
+func (st *variantState) checkpointedBuilder(arch string, remoteOpts ...remote.Option) string {
+	img, ok := st.checkpoint.Builders[arch]
+	if !ok {
+		return ""
+	}
+	ref, err := name.NewDigest(img)
+	if err != nil {
+		return ""
+	}
+	_, err = remote.Head(ref, remoteOpts...)
+	if err != nil {
+		return ""
+	}
+	fmt.Printf("## reusing %s builder: '%v'\n", arch, img)
+	return img
+}
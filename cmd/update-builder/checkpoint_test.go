@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	cfg := defaultConfig()
+
+	cp, err := loadCheckpoint(path, true, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vc := cp.variant(variantKey(distroJammy, "base", nil))
+	vc.Release = "v0.4.0"
+	vc.Versions = map[string]string{"paketo-community/rust": "0.65.0"}
+	vc.Pinned["docker.io/paketobuildpacks/java:18.9.0"] = "docker.io/paketobuildpacks/java@sha256:abc"
+	vc.StackMirrored = true
+	if err = cp.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := loadCheckpoint(path, true, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rvc := resumed.variant("jammy-base")
+	if rvc.Release != "v0.4.0" || rvc.Versions["paketo-community/rust"] != "0.65.0" || !rvc.StackMirrored || len(rvc.Pinned) != 1 {
+		t.Errorf("checkpoint not restored: %+v", rvc)
+	}
+
+	fresh, err := loadCheckpoint(path, false, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.variant("jammy-base").Release != "" {
+		t.Error("checkpoint restored without resume")
+	}
+
+	cfg.PinDigests = !cfg.PinDigests
+	_, err = loadCheckpoint(path, true, &cfg)
+	if err == nil {
+		t.Error("expected error for checkpoint of different configuration")
+	}
+
+	_, err = loadCheckpoint("", true, &cfg)
+	if err == nil {
+		t.Error("expected error for resume without state file")
+	}
+}
+
+func TestCheckpointedBuilder(t *testing.T) {
+	reg := testRegistry(t)
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := pushTestImage(t, reg+"/knative/builder-jammy-base:v0.4.0-amd64", img)
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := variantState{checkpoint: &variantCheckpoint{Builders: map[string]string{
+		"amd64": ref.Context().Name() + "@" + digest.String(),
+		// pushed to the local registry of another runner
+		"arm64": ref.Context().Name() + "@sha256:" + strings.Repeat("0", 64),
+	}}}
+	if got := st.checkpointedBuilder("amd64"); got != st.checkpoint.Builders["amd64"] {
+		t.Errorf("expected amd64 builder to be reused, got %q", got)
+	}
+	if got := st.checkpointedBuilder("arm64"); got != "" {
+		t.Errorf("expected missing arm64 builder not to be reused, got %q", got)
+	}
+}
+
+func TestCheckpointResumedResult(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	cfg := defaultConfig()
+	cfg.PinDigests = true
+
+	cp, err := loadCheckpoint(path, false, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the run publishing the index fills its result and checkpoint alike
+	vc := cp.variant(variantKey(distroJammy, "base", nil))
+	var published buildResult
+	res := published.variant("jammy-base")
+	vc.Release, vc.Versions = "v0.4.0", map[string]string{"paketo-community/rust": "0.65.0"}
+	res.Release, res.Buildpacks = vc.Release, vc.Versions
+	vc.Pinned["docker.io/paketobuildpacks/java:18.9.0"] = "docker.io/paketobuildpacks/java@sha256:abc"
+	res.Digests = vc.Pinned
+	for _, arch := range []string{"amd64", "arm64"} {
+		vc.Builders[arch] = "localhost:5000/builder-jammy-base@sha256:" + arch
+		res.arch(arch).Image = vc.Builders[arch]
+	}
+	res.Index = "ghcr.io/gauron99/builder-jammy-base:v0.4.0@sha256:idx"
+	res.Published = []string{res.Index, "quay.io/gauron99/builder-jammy-base:v0.4.0@sha256:idx"}
+	vc.Published, vc.Destinations = res.Index, res.Published
+	if err = cp.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := loadCheckpoint(path, true, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored buildResult
+	resumed.variant("jammy-base").restoreResult(restored.variant("jammy-base"))
+
+	want, err := json.Marshal(published)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("result of resumed run = %s, want %s", got, want)
+	}
+}
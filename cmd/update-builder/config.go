@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// config of the builder pipeline, see defaultConfig for the values used
+// when no configuration file is given.
+type config struct {
+	// Ubuntu distributions of upstream builders, see validDistro.
+	Distributions []string `json:"distributions"`
+	// Variants of upstream builders, e.g. "tiny", "base" or "full".
+	Variants []string `json:"variants"`
+	// Upstream repositories ("owner/name") of builders, keyed by builder name, e.g. "builder-noble-base".
+	// Builders not listed here are built from "paketo-buildpacks/<builder name>".
+	UpstreamRepos map[string]string `json:"upstreamRepos,omitempty"`
+	// Buildpacks added to the builder, each in its own order group before upstream groups.
+	Buildpacks []injectedBuildpack `json:"buildpacks"`
+	// Patches of composite buildpacks from the upstream builder.
+	Patches []compositePatch `json:"patches"`
+	// Registry namespaces builders are published to, e.g. "ghcr.io/gauron99" and "quay.io/gauron99".
+	// The same index is written to each of them. The first one is the primary destination signatures,
+	// release notes and downstream PRs refer to.
+	Destinations []string `json:"destinations"`
+	// Rewrite image references of the builder to digests, so the build is not affected by moved tags.
+	PinDigests bool `json:"pinDigests"`
+	// Registry namespace (e.g. "ghcr.io/gauron99/mirror") all images the builder needs are copied to.
+	// Stack images are mirrored to fixed locations when empty, other images are pulled from upstream.
+	Relocate string `json:"relocate,omitempty"`
+	// Rules mapping stack images to their mirrors, the first matching rule is used.
+	StackMirrors []mirrorRule `json:"stackMirrors"`
+	// Profile of an offline builder published next to the default one, e.g. with name "offline"
+	// and buildpacks "paketo-buildpacks/bellsoft-liberica" and "paketo-buildpacks/maven".
+	Offline *buildProfile `json:"offline,omitempty"`
+	// Path to PEM encoded ECDSA private key published indexes and their manifests are signed with, either
+	// not encrypted or written by "cosign generate-key-pair" with password in COSIGN_PASSWORD.
+	// Signatures are compatible with cosign and can be checked by the verify command.
+	SigningKey string `json:"signingKey,omitempty"`
+	// Attach CycloneDX SBOM and SLSA provenance to published indexes as OCI referrers. Disabled by default,
+	// the registry has to accept referrers of the index, which many do not for Docker manifest lists.
+	Attestations bool `json:"attestations"`
+	// Repository ("owner/name") a GitHub release with notes is created in for each published builder.
+	// The release is tagged "<builder>-<upstream release>", e.g. "builder-jammy-base-v0.4.0".
+	ReleaseRepo string `json:"releaseRepo,omitempty"`
+	// Repositories a PR bumping references to the published builders is opened in.
+	Downstream []downstreamRepo `json:"downstream,omitempty"`
+	// Labels of builder images and annotations of builder indexes. Placeholders like "{variant}" are
+	// substituted, see labelPlaceholders. The created, revision and base.name OCI labels are set
+	// automatically, labels with empty value are not set.
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	// Media type of published indexes, "docker" for Docker manifest list or "oci" for OCI image index.
+	// The registry is checked to accept it before builders are built.
+	IndexMediaType string `json:"indexMediaType"`
+	// Container engine builders are built with: "auto", "docker", "podman" or "daemonless".
+	// The auto engine uses DOCKER_HOST, docker socket or podman socket, whichever is found first,
+	// and builds daemonless if there is none.
+	Engine string `json:"engine"`
+	// Prefixes of images that exist only in the docker daemon, pack does not try to pull them.
+	// Buildpacks built by the run are not pulled without being listed here. Defaults to "ghcr.io/knative/buildpacks/".
+	DaemonOnlyPrefixes []string `json:"daemonOnlyPrefixes,omitempty"`
+	// Credentials of registries, used by pack, go-containerregistry and skopeo alike. Registries not listed
+	// here use GITHUB_TOKEN for ghcr.io and docker config. The GitHub API token of releases, downstream PRs
+	// and version lookups is not configured here, it is always read from GITHUB_TOKEN.
+	Registries []registryCredentials `json:"registries,omitempty"`
+	// Retry policies of GitHub, download and registry calls.
+	Retry retryConfig `json:"retry"`
+}
+
+// injectedBuildpack is a buildpack added to the upstream builder.
+type injectedBuildpack struct {
+	ID      string      `json:"id"`
+	Version versionSpec `json:"version"`
+	// URI of the buildpack image, "{version}" is substituted.
+	URI string `json:"uri"`
+	// Sentence appended to the description of the builder.
+	Description string `json:"description,omitempty"`
+}
+
+func defaultConfig() config {
+	return config{
+		Distributions:      []string{distroJammy},
+		Variants:           []string{"base"},
+		Destinations:       []string{"ghcr.io/gauron99"},
+		Engine:             engineAuto,
+		IndexMediaType:     indexTypeDocker,
+		DaemonOnlyPrefixes: []string{"ghcr.io/knative/buildpacks/"},
+		Labels:             defaultLabels(),
+		Annotations:        defaultLabels(),
+		Retry: retryConfig{
+			// secondary rate limits of GitHub usually ask to wait a minute
+			GitHub:   retryPolicy{Attempts: 5, InitialDelay: duration(time.Second), MaxDelay: duration(2 * time.Minute)},
+			Download: retryPolicy{Attempts: 4, InitialDelay: duration(time.Second), MaxDelay: duration(30 * time.Second)},
+			Registry: retryPolicy{Attempts: 4, InitialDelay: duration(time.Second), MaxDelay: duration(30 * time.Second)},
+		},
+		Buildpacks: []injectedBuildpack{
+			{
+				ID: "paketo-community/rust",
+				Version: versionSpec{
+					Strategy: strategyExact,
+					Version:  "0.65.0",
+				},
+				URI:         "docker://docker.io/paketocommunity/rust:{version}",
+				Description: "Addendum: this builder contains community multi-arch Rust buildpack.",
+			},
+		},
+		StackMirrors: []mirrorRule{
+			{
+				// build image is only needed to create the builder
+				Regex:       `^(?:.*/)?(?P<name>build-[^/]+)$`,
+				Destination: "localhost:5000/${name}",
+			},
+			{
+				Regex:       `^(?:.*/)?(?P<name>run-[^/]+)$`,
+				Destination: "ghcr.io/gauron99/${name}",
+			},
+			{
+				// noble stacks are named e.g. ubuntu-noble-build and ubuntu-noble-run-tiny
+				Regex:       `^(?:.*/)?(?P<name>ubuntu-[a-z]+-build[^/]*)$`,
+				Destination: "localhost:5000/${name}",
+			},
+			{
+				Regex:       `^(?:.*/)?(?P<name>ubuntu-[a-z]+-run[^/]*)$`,
+				Destination: "ghcr.io/gauron99/${name}",
+			},
+		},
+		Patches: []compositePatch{
+			{
+				// include Quarkus BP just before Maven BP
+				Buildpacks: []string{"paketo-buildpacks/java", "paketo-buildpacks/java-native-image"},
+				Operations: []orderPatch{
+					{
+						Op:     opInsertBefore,
+						Target: "paketo-buildpacks/maven",
+						Groups: []int{0},
+						Module: patchModule{
+							ID: "paketo-buildpacks/quarkus",
+							Version: versionSpec{
+								Strategy: strategyLatest,
+								GitHub:   "paketo-buildpacks/quarkus",
+							},
+							URI:      "docker://index.docker.io/paketobuildpacks/quarkus:{version}",
+							Optional: true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// loads config from JSON file at path, fields not present in the file keep their default values
+func loadConfig(path string) (config, error) {
+	cfg := defaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("cannot read config: %w", err)
+	}
+	err = json.Unmarshal(bs, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("cannot parse config: %w", err)
+	}
+	for _, distro := range cfg.Distributions {
+		if !validDistro(distro) {
+			return cfg, fmt.Errorf("unsupported distribution %q", distro)
+		}
+	}
+	for builder, repo := range cfg.UpstreamRepos {
+		if owner, name, ok := strings.Cut(repo, "/"); !ok || owner == "" || name == "" {
+			return cfg, fmt.Errorf("invalid upstream repository %q of %q", repo, builder)
+		}
+	}
+	for _, templates := range []map[string]string{cfg.Labels, cfg.Annotations} {
+		err = checkLabelTemplates(templates)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid labels: %w", err)
+		}
+	}
+	if !slices.Contains([]string{engineAuto, engineDocker, enginePodman, engineDaemonless}, cfg.Engine) {
+		return cfg, fmt.Errorf("unsupported container engine %q", cfg.Engine)
+	}
+	if len(cfg.Destinations) == 0 {
+		return cfg, fmt.Errorf("no destinations to publish to")
+	}
+	for _, rc := range cfg.Registries {
+		err = rc.validate()
+		if err != nil {
+			return cfg, err
+		}
+	}
+	_, err = indexMediaType(cfg.IndexMediaType)
+	if err != nil {
+		return cfg, err
+	}
+	for _, p := range cfg.Patches {
+		err = p.validate()
+		if err != nil {
+			return cfg, fmt.Errorf("invalid patch of %v: %w", p.Buildpacks, err)
+		}
+	}
+	if cfg.Offline != nil && cfg.Offline.Name == "" {
+		return cfg, fmt.Errorf("name of the offline profile is not set")
+	}
+	return cfg, nil
+}
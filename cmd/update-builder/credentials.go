@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker-credential-helpers/client"
+	"github.com/google/go-containerregistry/pkg/authn"
+	ghAuth "github.com/google/go-containerregistry/pkg/authn/github"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// registryCredentials is the source of credentials of a registry, exactly one of
+// password, identity token or credential helper is set.
+type registryCredentials struct {
+	// Host of the registry, e.g. "ghcr.io", "quay.io" or "docker.io".
+	Registry string `json:"registry"`
+	// Username, or environment variable holding it.
+	Username    string `json:"username,omitempty"`
+	UsernameEnv string `json:"usernameEnv,omitempty"`
+	// Environment variable or file holding the password or access token.
+	PasswordEnv  string `json:"passwordEnv,omitempty"`
+	PasswordFile string `json:"passwordFile,omitempty"`
+	// Environment variable holding OAuth2 refresh token exchanged for a registry token,
+	// e.g. of Azure Container Registry.
+	IdentityTokenEnv string `json:"identityTokenEnv,omitempty"`
+	// Docker credential helper, e.g. "ecr-login" for docker-credential-ecr-login.
+	Helper string `json:"helper,omitempty"`
+}
+
+func (rc registryCredentials) validate() error {
+	reg, err := name.NewRegistry(rc.Registry)
+	if err != nil || rc.Registry == "" {
+		return fmt.Errorf("invalid registry %q", rc.Registry)
+	}
+	var sources int
+	for _, s := range []string{rc.PasswordEnv + rc.PasswordFile, rc.IdentityTokenEnv, rc.Helper} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 || (rc.PasswordEnv != "" && rc.PasswordFile != "") {
+		return fmt.Errorf("credentials of %s must have exactly one of password, identity token or helper", reg)
+	}
+	return nil
+}
+
+// returns authenticator of the registry resource
+func (rc registryCredentials) authenticator(res authn.Resource) (authn.Authenticator, error) {
+	if rc.Helper != "" {
+		// the helper keychain knows server URL docker credential helpers expect for Docker Hub
+		return authn.NewKeychainFromHelper(credentialHelper(rc.Helper)).Resolve(res)
+	}
+
+	username := rc.Username
+	if rc.UsernameEnv != "" {
+		username = os.Getenv(rc.UsernameEnv)
+	}
+	secret, err := rc.secret()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get credentials of %s: %w", res.RegistryStr(), err)
+	}
+	if rc.IdentityTokenEnv != "" {
+		return authn.FromConfig(authn.AuthConfig{Username: username, IdentityToken: secret}), nil
+	}
+	return authn.FromConfig(authn.AuthConfig{Username: username, Password: secret}), nil
+}
+
+func (rc registryCredentials) secret() (string, error) {
+	if rc.PasswordFile != "" {
+		bs, err := os.ReadFile(rc.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(bs)), nil
+	}
+	env := rc.PasswordEnv + rc.IdentityTokenEnv
+	s, ok := os.LookupEnv(env)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", env)
+	}
+	return s, nil
+}
+
+// credentialHelper runs docker-credential-<name>
+type credentialHelper string
+
+func (h credentialHelper) Get(serverURL string) (string, string, error) {
+	creds, err := client.Get(client.NewShellProgramFunc("docker-credential-"+string(h)), serverURL)
+	if err != nil {
+		return "", "", err
+	}
+	return creds.Username, creds.Secret, nil
+}
+
+// registryKeychain resolves credentials of the configured registries, other registries
+// are resolved from GITHUB_TOKEN for ghcr.io and from docker config.
+type registryKeychain struct {
+	registries []registryCredentials
+	fallback   authn.Keychain
+}
+
+func newRegistryKeychain(registries []registryCredentials) authn.Keychain {
+	return registryKeychain{
+		registries: registries,
+		fallback:   authn.NewMultiKeychain(ghAuth.Keychain, authn.DefaultKeychain),
+	}
+}
+
+func (k registryKeychain) Resolve(res authn.Resource) (authn.Authenticator, error) {
+	for _, rc := range k.registries {
+		// "docker.io" is the same registry as "index.docker.io"
+		reg, err := name.NewRegistry(rc.Registry)
+		if err == nil && reg.RegistryStr() == res.RegistryStr() {
+			return rc.authenticator(res)
+		}
+	}
+	return k.fallback.Resolve(res)
+}
+
+// Writes auth file of registries of the images in containers-auth.json format to dir, for tools
+// like skopeo that do not use the keychain. Registries without credentials are left out.
+func writeAuthFile(ctx context.Context, dir string, keychain authn.Keychain, imgs ...string) (string, error) {
+	type authEntry struct {
+		Auth          string `json:"auth,omitempty"`
+		IdentityToken string `json:"identitytoken,omitempty"`
+	}
+	auths := make(map[string]authEntry)
+	for _, img := range imgs {
+		ref, err := name.ParseReference(img)
+		if err != nil {
+			return "", fmt.Errorf("cannot parse reference %q: %w", img, err)
+		}
+		a, err := keychain.Resolve(ref.Context())
+		if err != nil {
+			return "", fmt.Errorf("cannot resolve credentials of %s: %w", ref.Context().RegistryStr(), err)
+		}
+		ac, err := authn.Authorization(ctx, a)
+		if err != nil {
+			return "", fmt.Errorf("cannot get credentials of %s: %w", ref.Context().RegistryStr(), err)
+		}
+		var entry authEntry
+		if ac.Username != "" || ac.Password != "" {
+			entry.Auth = base64.StdEncoding.EncodeToString([]byte(ac.Username + ":" + ac.Password))
+		}
+		entry.IdentityToken = ac.IdentityToken
+		if entry == (authEntry{}) {
+			continue
+		}
+		// containers-auth.json names Docker Hub "docker.io"
+		reg := ref.Context().RegistryStr()
+		if reg == name.DefaultRegistry {
+			reg = "docker.io"
+		}
+		auths[reg] = entry
+	}
+
+	bs, err := json.Marshal(map[string]any{"auths": auths})
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal auth file: %w", err)
+	}
+	path := filepath.Join(dir, "auth.json")
+	err = os.WriteFile(path, bs, 0600)
+	if err != nil {
+		return "", fmt.Errorf("cannot write auth file: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestRegistryKeychain(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "quay-password")
+	err := os.WriteFile(passwordFile, []byte("quay-secret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HUB_USER", "hubuser")
+	t.Setenv("HUB_TOKEN", "hub-secret")
+	t.Setenv("ACR_TOKEN", "refresh-token")
+
+	kc := registryKeychain{
+		registries: []registryCredentials{
+			{Registry: "docker.io", UsernameEnv: "HUB_USER", PasswordEnv: "HUB_TOKEN"},
+			{Registry: "quay.io", Username: "gauron99", PasswordFile: passwordFile},
+			{Registry: "example.azurecr.io", Username: "00000000-0000-0000-0000-000000000000", IdentityTokenEnv: "ACR_TOKEN"},
+			{Registry: "unset.example.com", PasswordEnv: "UNSET_PASSWORD"},
+		},
+		fallback: authn.NewMultiKeychain(),
+	}
+
+	tests := []struct {
+		img  string
+		want authn.AuthConfig
+	}{
+		{img: "paketobuildpacks/builder-jammy-base", want: authn.AuthConfig{Username: "hubuser", Password: "hub-secret"}},
+		{img: "index.docker.io/gauron99/builder-jammy-base", want: authn.AuthConfig{Username: "hubuser", Password: "hub-secret"}},
+		{img: "quay.io/gauron99/knative/run-jammy-base", want: authn.AuthConfig{Username: "gauron99", Password: "quay-secret"}},
+		{img: "example.azurecr.io/builder", want: authn.AuthConfig{Username: "00000000-0000-0000-0000-000000000000", IdentityToken: "refresh-token"}},
+		{img: "ghcr.io/gauron99/builder-jammy-base", want: authn.AuthConfig{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.img, func(t *testing.T) {
+			ref, err := name.ParseReference(tt.img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, err := kc.Resolve(ref.Context())
+			if err != nil {
+				t.Fatal(err)
+			}
+			ac, err := authn.Authorization(context.Background(), a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *ac != tt.want {
+				t.Errorf("credentials = %+v, want %+v", *ac, tt.want)
+			}
+		})
+	}
+
+	repo, err := name.NewRepository("unset.example.com/builder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = kc.Resolve(repo)
+	if err == nil {
+		t.Error("expected error for unset environment variable")
+	}
+}
+
+func TestWriteAuthFile(t *testing.T) {
+	t.Setenv("QUAY_TOKEN", "quay-secret")
+	kc := registryKeychain{
+		registries: []registryCredentials{
+			{Registry: "quay.io", Username: "gauron99", PasswordEnv: "QUAY_TOKEN"},
+			{Registry: "index.docker.io", Username: "hubuser", PasswordEnv: "QUAY_TOKEN"},
+		},
+		fallback: authn.NewMultiKeychain(),
+	}
+
+	path, err := writeAuthFile(context.Background(), t.TempDir(), kc,
+		"docker.io/paketobuildpacks/run-jammy-base:latest",
+		"quay.io/gauron99/knative/run-jammy-base:latest",
+		"localhost:5000/build-jammy-base:latest",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	err = json.Unmarshal(bs, &file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Auths) != 2 {
+		t.Errorf("auths = %s", bs)
+	}
+	// base64 of "gauron99:quay-secret"
+	if file.Auths["quay.io"].Auth != "Z2F1cm9uOTk6cXVheS1zZWNyZXQ=" {
+		t.Errorf("quay.io auth = %q", file.Auths["quay.io"].Auth)
+	}
+	if file.Auths["docker.io"].Auth == "" {
+		t.Errorf("docker.io is missing: %s", bs)
+	}
+}
+
+func TestRegistryCredentialsValidate(t *testing.T) {
+	tests := []struct {
+		rc    registryCredentials
+		valid bool
+	}{
+		{rc: registryCredentials{Registry: "ghcr.io", Username: "gauron99", PasswordEnv: "GITHUB_TOKEN"}, valid: true},
+		{rc: registryCredentials{Registry: "123.dkr.ecr.us-east-1.amazonaws.com", Helper: "ecr-login"}, valid: true},
+		{rc: registryCredentials{PasswordEnv: "TOKEN"}},
+		{rc: registryCredentials{Registry: "quay.io"}},
+		{rc: registryCredentials{Registry: "quay.io", PasswordEnv: "TOKEN", PasswordFile: "/run/secrets/quay"}},
+		{rc: registryCredentials{Registry: "quay.io", PasswordEnv: "TOKEN", Helper: "pass"}},
+	}
+	for _, tt := range tests {
+		err := tt.rc.validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("validate(%+v) = %v, want valid %v", tt.rc, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/buildpacks/pack/pkg/dist"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+const stackIDLabel = "io.buildpacks.stack.id"
+
+// builderSummary is what is compared by diffBuilders.
+type builderSummary struct {
+	ref    string
+	digest v1.Hash
+	// labels of the amd64 builder, builders of all arches contain the same buildpacks
+	labels  builderLabels
+	stackID string
+	// compressed size of the builder image of each arch (arch -> bytes)
+	sizes map[string]int64
+}
+
+// reads summary of the builder image or index
+func summarizeBuilder(ref name.Reference, remoteOpts ...remote.Option) (builderSummary, error) {
+	s := builderSummary{ref: ref.String(), sizes: make(map[string]int64)}
+	desc, err := remote.Get(ref, remoteOpts...)
+	if err != nil {
+		return s, fmt.Errorf("cannot get %q: %w", ref, err)
+	}
+	s.digest = desc.Digest
+
+	images := make(map[string]v1.Image)
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return s, fmt.Errorf("cannot get index: %w", err)
+		}
+		im, err := idx.IndexManifest()
+		if err != nil {
+			return s, fmt.Errorf("cannot get index manifest: %w", err)
+		}
+		for _, d := range im.Manifests {
+			if d.Platform == nil {
+				continue
+			}
+			images[d.Platform.Architecture], err = idx.Image(d.Digest)
+			if err != nil {
+				return s, fmt.Errorf("cannot get %s image: %w", d.Platform.Architecture, err)
+			}
+		}
+	} else {
+		img, err := desc.Image()
+		if err != nil {
+			return s, fmt.Errorf("cannot get image: %w", err)
+		}
+		cf, err := img.ConfigFile()
+		if err != nil {
+			return s, fmt.Errorf("cannot get config file for the image: %w", err)
+		}
+		images[cf.Architecture] = img
+	}
+	if len(images) == 0 {
+		return s, fmt.Errorf("%q contains no images", ref)
+	}
+
+	for arch, img := range images {
+		m, err := img.Manifest()
+		if err != nil {
+			return s, fmt.Errorf("cannot get %s manifest: %w", arch, err)
+		}
+		size := m.Config.Size
+		for _, l := range m.Layers {
+			size += l.Size
+		}
+		s.sizes[arch] = size
+	}
+
+	arch := "amd64"
+	if _, ok := images[arch]; !ok {
+		arch = slices.Sorted(maps.Keys(images))[0]
+	}
+	s.labels, err = readBuilderLabels(images[arch])
+	if err != nil {
+		return s, fmt.Errorf("cannot read labels of %s builder: %w", arch, err)
+	}
+	cf, err := images[arch].ConfigFile()
+	if err != nil {
+		return s, fmt.Errorf("cannot get config file for the image: %w", err)
+	}
+	s.stackID = cf.Config.Labels[stackIDLabel]
+	return s, nil
+}
+
+// returns Markdown describing changes between the old and the new builder
+func diffBuilders(from, to builderSummary) string {
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, "## Changes from `%s` to `%s`\n\n", from.ref, to.ref)
+
+	sb.WriteString("### Buildpacks\n\n")
+	rows := diffVersions(from.labels.Layers, to.labels.Layers)
+	if len(rows) == 0 {
+		sb.WriteString("No changes.\n\n")
+	} else {
+		sb.WriteString("| Buildpack | Old | New |\n|---|---|---|\n")
+		for _, row := range rows {
+			sb.WriteString(row)
+		}
+		sb.WriteString("\n")
+	}
+
+	sb.WriteString("### Order\n\n")
+	oldGroups, newGroups := orderGroups(from.labels.Order), orderGroups(to.labels.Order)
+	if slices.Equal(oldGroups, newGroups) {
+		sb.WriteString("No changes.\n\n")
+	} else {
+		sb.WriteString("| Group | Old | New |\n|---|---|---|\n")
+		for i := 0; i < max(len(oldGroups), len(newGroups)); i++ {
+			o, n := at(oldGroups, i), at(newGroups, i)
+			if o != n {
+				_, _ = fmt.Fprintf(&sb, "| %d | %s | %s |\n", i, o, n)
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	sb.WriteString("### Stack\n\n| | Old | New |\n|---|---|---|\n")
+	for _, row := range [][3]string{
+		{"Stack", from.stackID, to.stackID},
+		{"Run image", from.labels.Metadata.Stack.RunImage.Image, to.labels.Metadata.Stack.RunImage.Image},
+		{"Run image mirrors", strings.Join(from.labels.Metadata.Stack.RunImage.Mirrors, ", "), strings.Join(to.labels.Metadata.Stack.RunImage.Mirrors, ", ")},
+		{"Lifecycle", from.labels.Metadata.Lifecycle.Version, to.labels.Metadata.Lifecycle.Version},
+	} {
+		_, _ = fmt.Fprintf(&sb, "| %s | %s | %s%s |\n", row[0], code(row[1]), code(row[2]), changed(row[1], row[2]))
+	}
+	sb.WriteString("\n")
+
+	sb.WriteString("### Size\n\n| Arch | Old | New | Change |\n|---|---|---|---|\n")
+	for _, arch := range slices.Sorted(maps.Keys(mergeKeys(from.sizes, to.sizes))) {
+		o, okFrom := from.sizes[arch]
+		n, okTo := to.sizes[arch]
+		change := ""
+		if okFrom && okTo {
+			change = formatSizeChange(n - o)
+		}
+		_, _ = fmt.Fprintf(&sb, "| %s | %s | %s | %s |\n", arch, formatSize(o, okFrom), formatSize(n, okTo), change)
+	}
+	return sb.String()
+}
+
+// returns table rows of buildpacks which were added, removed or changed version
+func diffVersions(from, to dist.ModuleLayers) []string {
+	var rows []string
+	for _, id := range slices.Sorted(maps.Keys(mergeKeys(from, to))) {
+		o := slices.Sorted(maps.Keys(from[id]))
+		n := slices.Sorted(maps.Keys(to[id]))
+		if slices.Equal(o, n) {
+			continue
+		}
+		rows = append(rows, fmt.Sprintf("| %s | %s | %s |\n", id, orDash(strings.Join(o, ", ")), orDash(strings.Join(n, ", "))))
+	}
+	return rows
+}
+
+// returns IDs of modules of each order group, optional ones are suffixed with "?"
+func orderGroups(order dist.Order) []string {
+	groups := make([]string, len(order))
+	for i, entry := range order {
+		ids := make([]string, len(entry.Group))
+		for j, ref := range entry.Group {
+			ids[j] = ref.ID
+			if ref.Optional {
+				ids[j] += "?"
+			}
+		}
+		groups[i] = strings.Join(ids, ", ")
+	}
+	return groups
+}
+
+func mergeKeys[V any](a, b map[string]V) map[string]struct{} {
+	keys := make(map[string]struct{}, len(a)+len(b))
+	for k := range a {
+		keys[k] = struct{}{}
+	}
+	for k := range b {
+		keys[k] = struct{}{}
+	}
+	return keys
+}
+
+func at(s []string, i int) string {
+	if i < len(s) {
+		return s[i]
+	}
+	return "-"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func code(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return "`" + s + "`"
+}
+
+func changed(from, to string) string {
+	if from != to {
+		return " (changed)"
+	}
+	return ""
+}
+
+func formatSize(size int64, ok bool) string {
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f MiB", float64(size)/(1<<20))
+}
+
+func formatSizeChange(delta int64) string {
+	if delta >= 0 {
+		return "+" + formatSize(delta, true)
+	}
+	return "-" + formatSize(-delta, true)
+}
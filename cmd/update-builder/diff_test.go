@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/buildpacks/pack/pkg/dist"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestDiffBuilders(t *testing.T) {
+	reg := testRegistry(t)
+
+	labels := func(quarkusVersion, lifecycle string, groups ...string) builderLabels {
+		var bl builderLabels
+		for _, id := range groups {
+			bl.Order = append(bl.Order, dist.OrderEntry{Group: []dist.ModuleRef{{ModuleInfo: dist.ModuleInfo{ID: id}}}})
+		}
+		bl.Layers = dist.ModuleLayers{
+			"paketo-buildpacks/java":    {"18.9.0": {}},
+			"paketo-buildpacks/quarkus": {quarkusVersion: {}},
+		}
+		bl.Metadata.Stack.RunImage.Image = "ghcr.io/gauron99/run-jammy-base:latest"
+		bl.Metadata.Lifecycle.Version = lifecycle
+		return bl
+	}
+
+	push := func(tag string, bl builderLabels) builderSummary {
+		idx := mutate.IndexMediaType(empty.Index, types.DockerManifestList)
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add: builderImage(t, bl),
+			Descriptor: v1.Descriptor{
+				MediaType: types.DockerManifestSchema2,
+				Platform:  &v1.Platform{OS: "linux", Architecture: "amd64"},
+			},
+		})
+		ref := pushTestIndex(t, reg+"/gauron99/builder-jammy-base:"+tag, idx)
+		s, err := summarizeBuilder(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	from := push("0.1.0", labels("1.2.3", "0.20.0", "paketo-community/rust", "paketo-buildpacks/java"))
+	to := push("0.1.1", labels("1.2.4", "0.20.0", "paketo-community/rust", "paketo-buildpacks/java", "paketo-buildpacks/go"))
+
+	md := diffBuilders(from, to)
+	for _, want := range []string{
+		"| paketo-buildpacks/quarkus | 1.2.3 | 1.2.4 |",
+		"| 2 | - | paketo-buildpacks/go |",
+		"| Lifecycle | `0.20.0` | `0.20.0` |",
+		"| amd64 |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("diff does not contain %q:\n%s", want, md)
+		}
+	}
+	if strings.Contains(md, "paketo-buildpacks/java |") {
+		t.Errorf("unchanged buildpack in diff:\n%s", md)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// Ubuntu distributions upstream builders are based on.
+const (
+	distroJammy = "jammy"
+	distroNoble = "noble"
+)
+
+func validDistro(distro string) bool {
+	return distro == distroJammy || distro == distroNoble
+}
+
+// name of the builder repository and image, e.g. "builder-jammy-base"
+func builderName(distro, variant string) string {
+	return "builder-" + distro + "-" + variant
+}
+
+// returns owner and name of the upstream repository releases of the builder are built from. Paketo names
+// them the same as our builders (github.com/paketo-buildpacks/builder-jammy-base), other names are configured.
+func upstreamRepo(cfg *config, distro, variant string) (string, string) {
+	bn := builderName(distro, variant)
+	if repo, ok := cfg.UpstreamRepos[bn]; ok {
+		owner, name, _ := strings.Cut(repo, "/")
+		return owner, name
+	}
+	return "paketo-buildpacks", bn
+}
+
+// key of the variant in the result and the checkpoint, e.g. "jammy-base-offline"
+func variantKey(distro, variant string, profile *buildProfile) string {
+	return distro + "-" + variant + profile.tagSuffix()
+}
+
+// returns distribution and variant of a builder from the name of its repository, inverse of builderName
+func parseBuilderName(repo string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(path.Base(repo), "builder-")
+	if !ok {
+		return "", "", false
+	}
+	distro, variant, ok := strings.Cut(rest, "-")
+	if !ok || !validDistro(distro) || variant == "" {
+		return "", "", false
+	}
+	return distro, variant, true
+}
+
+// arches builders of the variant are built for, there is no arm64 full builder upstream
+func builderArches(variant string) []string {
+	if variant == "full" {
+		return []string{"amd64"}
+	}
+	return []string{"arm64", "amd64"}
+}
+
+// e.g. "Noble" for "noble"
+func distroTitle(distro string) string {
+	if distro == "" {
+		return ""
+	}
+	return strings.ToUpper(distro[:1]) + distro[1:]
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDistroNames(t *testing.T) {
+	if got := builderName(distroNoble, "base"); got != "builder-noble-base" {
+		t.Errorf("unexpected builder name %q", got)
+	}
+	if got := distroTitle(distroNoble); got != "Noble" {
+		t.Errorf("unexpected title %q", got)
+	}
+}
+
+func TestParseBuilderName(t *testing.T) {
+	distro, variant, ok := parseBuilderName("gauron99/" + builderName(distroNoble, "base"))
+	if !ok || distro != distroNoble || variant != "base" {
+		t.Errorf("unexpected result %q %q %v", distro, variant, ok)
+	}
+	for _, repo := range []string{"gauron99/builder-focal-base", "gauron99/jammy-base-stack", "builder-jammy"} {
+		if _, _, ok := parseBuilderName(repo); ok {
+			t.Errorf("expected %q not to be a builder name", repo)
+		}
+	}
+}
+
+func TestUpstreamRepo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{"upstreamRepos": {"builder-noble-base": "paketo-buildpacks/ubuntu-noble-builder"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner, repo := upstreamRepo(&cfg, distroJammy, "base"); owner != "paketo-buildpacks" || repo != "builder-jammy-base" {
+		t.Errorf("unexpected upstream repository %s/%s", owner, repo)
+	}
+	if owner, repo := upstreamRepo(&cfg, distroNoble, "base"); owner != "paketo-buildpacks" || repo != "ubuntu-noble-builder" {
+		t.Errorf("unexpected upstream repository %s/%s", owner, repo)
+	}
+
+	err = os.WriteFile(path, []byte(`{"upstreamRepos": {"builder-noble-base": "ubuntu-noble-builder"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadConfig(path)
+	if err == nil {
+		t.Error("expected error for upstream repository without owner")
+	}
+}
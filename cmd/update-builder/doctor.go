@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pelletier/go-toml"
+)
+
+// binfmt_misc entry QEMU registers for arm64 binaries
+const qemuBinfmt = "/proc/sys/fs/binfmt_misc/qemu-aarch64"
+
+// Checks prerequisites of the builder pipeline the workflow sets up by hand, so a run does not fail late
+// on a missing tool, registry or credentials. Failed checks say how to fix the problem.
+func doctor(ctx context.Context, cfg *config, engine *containerEngine) *inspectReport {
+	fmt.Println("#### doctor")
+	report := &inspectReport{ref: "environment"}
+
+	tools := []string{"skopeo"}
+	if len(cfg.Downstream) > 0 {
+		tools = append(tools, "git")
+	}
+	for _, tool := range tools {
+		report.add(tool, checkTool(tool, exec.LookPath))
+	}
+
+	if !engine.daemonless() {
+		report.add(engine.name+" daemon", checkDaemon(ctx, engine))
+	}
+
+	report.add("local registry", checkLocalRegistry(ctx, localRegistry))
+	if engine.name == engineDocker {
+		report.add("local registry insecure in docker", checkDockerInsecure(ctx, engine, localRegistry))
+	}
+	home, _ := os.UserHomeDir()
+	report.add("local registry insecure in registries.conf", checkRegistriesConf([]string{
+		filepath.Join(home, ".config", "containers", "registries.conf"),
+		"/etc/containers/registries.conf",
+	}, localRegistry))
+
+	if needsQEMU(cfg, runtime.GOARCH) {
+		report.add("QEMU for arm64", checkBinfmt(qemuBinfmt))
+	}
+
+	t := newRetryTransport("registry", retryPolicies.Registry, remote.DefaultTransport)
+	for _, distro := range cfg.Distributions {
+		for _, variant := range cfg.Variants {
+			repos, err := builderRepos(cfg, distro, variant)
+			if err != nil {
+				report.add("destinations", err)
+				continue
+			}
+			for _, repo := range repos {
+				report.add("push to "+repo.String(), checkPushCredentials(repo, DefaultKeychain, t))
+			}
+		}
+	}
+
+	if cfg.ReleaseRepo != "" || len(cfg.Downstream) > 0 {
+		var err error
+		if os.Getenv("GITHUB_TOKEN") == "" {
+			err = errors.New("GITHUB_TOKEN is not set, releases and downstream PRs need a token with contents and pull-requests write permission")
+		}
+		report.add("GitHub token", err)
+	}
+	return report
+}
+
+func checkTool(tool string, lookPath func(string) (string, error)) error {
+	_, err := lookPath(tool)
+	if err != nil {
+		return fmt.Errorf("%s not found in PATH, install it, e.g. \"sudo apt-get install %s\" or \"sudo dnf install %s\"", tool, tool, tool)
+	}
+	return nil
+}
+
+func checkDaemon(ctx context.Context, engine *containerEngine) error {
+	cli, err := engine.dockerClient(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = cli.Close()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, err = cli.Ping(ctx)
+	if err != nil {
+		if engine.name == enginePodman {
+			return fmt.Errorf("cannot reach podman at %s, start it with \"systemctl --user enable --now podman.socket\": %w", engine.host, err)
+		}
+		return fmt.Errorf("cannot reach docker daemon, start it or set DOCKER_HOST, or use \"daemonless\" engine: %w", err)
+	}
+	return nil
+}
+
+// checks that the registry the builders are built into is running
+func checkLocalRegistry(ctx context.Context, host string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err == nil {
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("registry at %s is not running, start it with \"docker run -d -p 5000:5000 --name registry registry:2.7\": %w", host, err)
+	}
+	return nil
+}
+
+// checks that docker pushes to the registry over plain HTTP
+func checkDockerInsecure(ctx context.Context, engine *containerEngine, host string) error {
+	cli, err := engine.dockerClient(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = cli.Close()
+	}()
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get docker info: %w", err)
+	}
+	fix := fmt.Errorf("add \"insecure-registries\": [%q] to /etc/docker/daemon.json and restart docker", host)
+	if info.RegistryConfig == nil {
+		return fix
+	}
+	if idx, ok := info.RegistryConfig.IndexConfigs[host]; ok {
+		if idx.Secure {
+			return fix
+		}
+		return nil
+	}
+	// localhost is in 127.0.0.0/8, which docker treats as insecure unless configured otherwise
+	hostname, _, _ := strings.Cut(host, ":")
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, hostname)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s: %w", hostname, err)
+	}
+	for _, cidr := range info.RegistryConfig.InsecureRegistryCIDRs {
+		if slices.ContainsFunc(ips, func(ip net.IPAddr) bool {
+			return (*net.IPNet)(cidr).Contains(ip.IP)
+		}) {
+			return nil
+		}
+	}
+	return fix
+}
+
+// Checks that the registry is insecure in registries.conf, skopeo and podman do not use plain HTTP otherwise.
+// The first existing file of the paths is used, the same way containers/image uses the user file over the system one.
+func checkRegistriesConf(paths []string, host string) error {
+	fix := fmt.Errorf("add [[registry]] with location = %q and insecure = true to %s", host, paths[0])
+	for _, path := range paths {
+		bs, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read %s: %w", path, err)
+		}
+		var conf struct {
+			Registry []struct {
+				Location string `toml:"location"`
+				Insecure bool   `toml:"insecure"`
+			} `toml:"registry"`
+		}
+		err = toml.Unmarshal(bs, &conf)
+		if err != nil {
+			return fmt.Errorf("cannot parse %s: %w", path, err)
+		}
+		for _, reg := range conf.Registry {
+			if reg.Location == host && reg.Insecure {
+				return nil
+			}
+		}
+		return fix
+	}
+	return fix
+}
+
+// returns whether arm64 builders are built on a host of another arch
+func needsQEMU(cfg *config, hostArch string) bool {
+	if hostArch == "arm64" {
+		return false
+	}
+	return slices.ContainsFunc(cfg.Variants, func(variant string) bool {
+		return slices.Contains(builderArches(variant), "arm64")
+	})
+}
+
+func checkBinfmt(path string) error {
+	bs, err := os.ReadFile(path)
+	if err != nil || !strings.HasPrefix(string(bs), "enabled") {
+		return errors.New("QEMU binfmt handler for arm64 is not registered, register it with " +
+			"\"docker run --privileged --rm tonistiigi/binfmt --install arm64\"")
+	}
+	return nil
+}
+
+// checks that there are credentials of the repository allowing to push to it
+func checkPushCredentials(repo name.Repository, keychain authn.Keychain, t http.RoundTripper) error {
+	fix := fmt.Sprintf("configure credentials of %s in \"registries\" of the configuration", repo.RegistryStr())
+	if repo.RegistryStr() == "ghcr.io" {
+		fix = "set GITHUB_TOKEN with packages write permission or " + fix
+	}
+
+	a, err := keychain.Resolve(repo)
+	if err != nil {
+		return fmt.Errorf("%w, %s", err, fix)
+	}
+	if a == authn.Anonymous {
+		return fmt.Errorf("no credentials, %s", fix)
+	}
+	err = remote.CheckPushPermission(repo.Tag("latest"), keychain, t)
+	if err != nil {
+		return fmt.Errorf("cannot push: %w, %s", err, fix)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+)
+
+func TestCheckTool(t *testing.T) {
+	found := func(string) (string, error) { return "/usr/bin/skopeo", nil }
+	missing := func(string) (string, error) { return "", errors.New("not found") }
+	if err := checkTool("skopeo", found); err != nil {
+		t.Error(err)
+	}
+	if err := checkTool("skopeo", missing); err == nil || !strings.Contains(err.Error(), "install skopeo") {
+		t.Errorf("expected error with fix, got: %v", err)
+	}
+}
+
+func TestCheckLocalRegistry(t *testing.T) {
+	srv := httptest.NewServer(registry.New())
+	host := strings.TrimPrefix(srv.URL, "http://")
+	err := checkLocalRegistry(context.Background(), host)
+	if err != nil {
+		t.Error(err)
+	}
+	srv.Close()
+	err = checkLocalRegistry(context.Background(), host)
+	if err == nil || !strings.Contains(err.Error(), "docker run") {
+		t.Errorf("expected error with fix, got: %v", err)
+	}
+}
+
+func TestCheckRegistriesConf(t *testing.T) {
+	dir := t.TempDir()
+	write := func(file, content string) string {
+		path := filepath.Join(dir, file)
+		err := os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	insecure := write("insecure.conf", "unqualified-search-registries = [\"docker.io\"]\n\n[[registry]]\nlocation = \"localhost:5000\"\ninsecure = true\n")
+	secure := write("secure.conf", "[[registry]]\nlocation = \"localhost:5000\"\n")
+	missing := filepath.Join(dir, "missing.conf")
+
+	tests := []struct {
+		name  string
+		paths []string
+		ok    bool
+	}{
+		{name: "user file", paths: []string{insecure, secure}, ok: true},
+		{name: "system file", paths: []string{missing, insecure}, ok: true},
+		{name: "user file overrides system file", paths: []string{secure, insecure}},
+		{name: "no file", paths: []string{missing}},
+	}
+	for _, tt := range tests {
+		err := checkRegistriesConf(tt.paths, "localhost:5000")
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestNeedsQEMU(t *testing.T) {
+	cfg := defaultConfig()
+	if !needsQEMU(&cfg, "amd64") {
+		t.Error("base builder is built for arm64")
+	}
+	if needsQEMU(&cfg, "arm64") {
+		t.Error("arm64 host does not need QEMU")
+	}
+	cfg.Variants = []string{"full"}
+	if needsQEMU(&cfg, "amd64") {
+		t.Error("full builder is built only for amd64")
+	}
+}
+
+func TestCheckBinfmt(t *testing.T) {
+	dir := t.TempDir()
+	enabled := filepath.Join(dir, "qemu-aarch64")
+	err := os.WriteFile(enabled, []byte("enabled\ninterpreter /usr/bin/qemu-aarch64-static\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disabled := filepath.Join(dir, "disabled")
+	err = os.WriteFile(disabled, []byte("disabled\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkBinfmt(enabled); err != nil {
+		t.Error(err)
+	}
+	for _, path := range []string{disabled, filepath.Join(dir, "missing")} {
+		if err := checkBinfmt(path); err == nil {
+			t.Errorf("%s: expected error", path)
+		}
+	}
+}
+
+func TestCheckPushCredentials(t *testing.T) {
+	repo, err := name.NewRepository(testRegistry(t) + "/gauron99/builder-jammy-base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_REGISTRY_TOKEN", "secret")
+	kc := registryKeychain{
+		registries: []registryCredentials{{Registry: repo.RegistryStr(), Username: "gauron99", PasswordEnv: "TEST_REGISTRY_TOKEN"}},
+		fallback:   authn.NewMultiKeychain(),
+	}
+	err = checkPushCredentials(repo, kc, http.DefaultTransport)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = checkPushCredentials(repo, authn.NewMultiKeychain(), http.DefaultTransport)
+	if err == nil || !strings.Contains(err.Error(), "no credentials") {
+		t.Errorf("expected error for missing credentials, got: %v", err)
+	}
+
+	kc.registries[0].PasswordEnv = "UNSET_REGISTRY_TOKEN"
+	err = checkPushCredentials(repo, kc, http.DefaultTransport)
+	if err == nil || !strings.Contains(err.Error(), "UNSET_REGISTRY_TOKEN") {
+		t.Errorf("expected error for unset variable, got: %v", err)
+	}
+}
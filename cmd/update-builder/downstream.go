@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-github/v68/github"
+)
+
+const downstreamPRTitle = "chore: update builder images"
+
+// downstreamRepo is a repository referencing published builders, e.g. knative/func.
+// A PR rewriting the references to digests of the newly published builders is opened in it.
+type downstreamRepo struct {
+	// Repository ("owner/name") the PR is opened in.
+	Repo string `json:"repo"`
+	// Repository ("owner/name") the branch is pushed to when it cannot be pushed to Repo.
+	Fork string `json:"fork,omitempty"`
+	// Branch the PR is opened against, "main" when empty.
+	Base string `json:"base,omitempty"`
+	// Branch the changes are pushed to, "update-builder-images" when empty.
+	Branch string `json:"branch,omitempty"`
+	// Files referencing the builders, relative to the root of the repository.
+	Files []string `json:"files"`
+	// Namespaces referenced by the files mapped to the destinations the builders are published to, e.g.
+	// "ghcr.io/knative" to "ghcr.io/gauron99". References in the namespace are rewritten to the published builders.
+	// References to the destinations are always rewritten.
+	Namespaces map[string]string `json:"namespaces,omitempty"`
+}
+
+func (d downstreamRepo) base() string {
+	if d.Base == "" {
+		return "main"
+	}
+	return d.Base
+}
+
+func (d downstreamRepo) branch() string {
+	if d.Branch == "" {
+		return "update-builder-images"
+	}
+	return d.Branch
+}
+
+// repository the branch is pushed to
+func (d downstreamRepo) head() string {
+	if d.Fork == "" {
+		return d.Repo
+	}
+	return d.Fork
+}
+
+// publishedIndex is a builder index published by the run.
+type publishedIndex struct {
+	digest name.Digest
+	// tag the index is released as, e.g. "v0.4.0-offline"
+	tag string
+	// suffix of the tag of the builder profile, e.g. "-offline"
+	tagSuffix string
+}
+
+// returns builder indexes of the result
+func publishedIndexes(result *buildResult) ([]publishedIndex, error) {
+	var indexes []publishedIndex
+	for _, key := range slices.Sorted(maps.Keys(result.Variants)) {
+		res := result.Variants[key]
+		if res.Index == "" {
+			continue
+		}
+		d, err := name.NewDigest(res.Index)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse index reference %q: %w", res.Index, err)
+		}
+		ref, _, _ := strings.Cut(res.Index, "@")
+		tag := strings.TrimPrefix(ref, d.Context().Name()+":")
+		indexes = append(indexes, publishedIndex{
+			digest:    d,
+			tag:       tag,
+			tagSuffix: strings.TrimPrefix(tag, res.Release),
+		})
+	}
+	return indexes, nil
+}
+
+// returns the index a reference with the tag is rewritten to, the one with the longest matching profile suffix
+func matchIndex(indexes []publishedIndex, tag string) (publishedIndex, bool) {
+	var (
+		match publishedIndex
+		found bool
+	)
+	for _, idx := range indexes {
+		if !strings.HasSuffix(tag, idx.tagSuffix) {
+			continue
+		}
+		if !found || len(idx.tagSuffix) > len(match.tagSuffix) {
+			match, found = idx, true
+		}
+	}
+	return match, found
+}
+
+// Rewrites references of the builders to the published indexes, tagged references get the release tag
+// and the digest, references by digest only get the digest. References in the namespaces (referenced
+// namespace -> destination) are rewritten to the builders published to the destination.
+// Returns the new content and the number of rewritten references.
+func rewriteImageRefs(content string, indexes []publishedIndex, namespaces map[string]string) (string, int) {
+	// referenced repository -> indexes
+	byRepo := make(map[string][]publishedIndex)
+	for _, idx := range indexes {
+		repo := idx.digest.Context().Name()
+		byRepo[repo] = append(byRepo[repo], idx)
+		for src, dest := range namespaces {
+			if rest, ok := strings.CutPrefix(repo, strings.TrimSuffix(dest, "/")+"/"); ok {
+				srcRepo := strings.TrimSuffix(src, "/") + "/" + rest
+				byRepo[srcRepo] = append(byRepo[srcRepo], idx)
+			}
+		}
+	}
+
+	var n int
+	for _, repo := range slices.Sorted(maps.Keys(byRepo)) {
+		// the character following the reference is matched, so "builder-jammy-base" does not match "builder-jammy-base-offline"
+		re := regexp.MustCompile(regexp.QuoteMeta(repo) + `(:[\w][\w.-]{0,127})?(@sha256:[0-9a-f]{64})?([^\w.:@/-]|$)`)
+		content = re.ReplaceAllStringFunc(content, func(ref string) string {
+			m := re.FindStringSubmatch(ref)
+			idx, ok := matchIndex(byRepo[repo], strings.TrimPrefix(m[1], ":"))
+			if !ok {
+				return ref
+			}
+			newRef := idx.digest.Context().Name()
+			if m[1] != "" {
+				newRef += ":" + idx.tag
+			}
+			newRef += "@" + idx.digest.DigestStr() + m[3]
+			if newRef != ref {
+				n++
+			}
+			return newRef
+		})
+	}
+	return content, n
+}
+
+// pushes branch rewriting builder references in the downstream repository and opens PR for it
+func updateDownstream(ctx context.Context, client *github.Client, d downstreamRepo, indexes []publishedIndex) error {
+	fmt.Printf("#### updateDownstream %s\n", d.Repo)
+	dir, err := os.MkdirTemp("", "downstream-")
+	if err != nil {
+		return fmt.Errorf("cannot create temporary directory: %w", err)
+	}
+	defer func(path string) {
+		_ = os.RemoveAll(path)
+	}(dir)
+
+	baseRemote := "origin"
+	err = runGit(ctx, "", "clone", "--quiet", githubURL(d.head()), dir)
+	if err != nil {
+		return err
+	}
+	if d.Fork != "" {
+		baseRemote = "upstream"
+		err = runGit(ctx, dir, "remote", "add", baseRemote, githubURL(d.Repo))
+		if err != nil {
+			return err
+		}
+	}
+	err = runGit(ctx, dir, "fetch", "--quiet", baseRemote, d.base())
+	if err != nil {
+		return err
+	}
+	// the branch is recreated from the base, so the PR always contains a single commit
+	err = runGit(ctx, dir, "switch", "--quiet", "-C", d.branch(), baseRemote+"/"+d.base())
+	if err != nil {
+		return err
+	}
+
+	var changed int
+	for _, file := range d.Files {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("cannot read %q: %w", file, err)
+		}
+		content, n := rewriteImageRefs(string(bs), indexes, d.Namespaces)
+		if n == 0 {
+			continue
+		}
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			return fmt.Errorf("cannot write %q: %w", file, err)
+		}
+		fmt.Printf("## rewritten %d references in '%v'\n", n, file)
+		changed += n
+	}
+	if changed == 0 {
+		fmt.Println("references are up to date")
+		return nil
+	}
+
+	for key, val := range map[string]string{
+		"user.name":  "github-actions[bot]",
+		"user.email": "41898282+github-actions[bot]@users.noreply.github.com",
+	} {
+		err = runGit(ctx, dir, "config", key, val)
+		if err != nil {
+			return err
+		}
+	}
+	err = runGit(ctx, dir, "commit", "--quiet", "-a", "-m", downstreamPRTitle)
+	if err != nil {
+		return err
+	}
+	err = runGit(ctx, dir, "push", "--quiet", "-f", "origin", d.branch())
+	if err != nil {
+		return err
+	}
+
+	return ensurePR(ctx, client, d, downstreamPRBody(indexes))
+}
+
+func downstreamPRBody(indexes []publishedIndex) string {
+	var sb strings.Builder
+	sb.WriteString("Builder images were published by update-builder:\n\n")
+	for _, idx := range indexes {
+		_, _ = fmt.Fprintf(&sb, "- `%s`\n", idx.digest)
+	}
+	return sb.String()
+}
+
+// ensurePR updates body of the existing PR of the branch or creates a new one
+func ensurePR(ctx context.Context, client *github.Client, d downstreamRepo, body string) error {
+	owner, repo, ok := strings.Cut(d.Repo, "/")
+	if !ok {
+		return fmt.Errorf("invalid repository %q, expected <owner>/<name>", d.Repo)
+	}
+	headOwner, _, _ := strings.Cut(d.head(), "/")
+
+	pr, err := findPRByBranch(ctx, client, owner, repo, headOwner, d.branch())
+	if err != nil {
+		return fmt.Errorf("cannot check for existing PR: %w", err)
+	}
+
+	if pr != nil {
+		pr, _, err = client.PullRequests.Edit(ctx, owner, repo, pr.GetNumber(), &github.PullRequest{
+			Body: github.Ptr(body),
+		})
+		if err != nil {
+			return fmt.Errorf("cannot update PR: %w", err)
+		}
+		fmt.Printf("## PR updated: '%v'\n", pr.GetHTMLURL())
+		return nil
+	}
+
+	pr, _, err = client.PullRequests.Create(ctx, owner, repo, &github.NewPullRequest{
+		Title: github.Ptr(downstreamPRTitle),
+		Head:  github.Ptr(headOwner + ":" + d.branch()),
+		Base:  github.Ptr(d.base()),
+		Body:  github.Ptr(body),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot create PR: %w", err)
+	}
+	fmt.Printf("## PR created: '%v'\n", pr.GetHTMLURL())
+	return nil
+}
+
+// returns the open PR of the head branch or nil if there is none
+func findPRByBranch(ctx context.Context, client *github.Client, owner, repo, headOwner, branch string) (*github.PullRequest, error) {
+	prs, _, err := client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
+		State:       "open",
+		Head:        headOwner + ":" + branch, // GitHub API requires owner:branch format
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// head branch is unique, so there is at most one PR
+	if len(prs) > 0 {
+		return prs[0], nil
+	}
+	return nil, nil
+}
+
+// URL of the GitHub repository authenticated with GITHUB_TOKEN
+func githubURL(repo string) string {
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		return "https://x-access-token:" + token + "@github.com/" + repo + ".git"
+	}
+	return "https://github.com/" + repo + ".git"
+}
+
+func runGit(ctx context.Context, dir string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		// not printing all arguments, URLs may contain the token
+		return fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v68/github"
+)
+
+func TestRewriteImageRefs(t *testing.T) {
+	base := "sha256:" + strings.Repeat("a", 64)
+	offline := "sha256:" + strings.Repeat("b", 64)
+	indexes, err := publishedIndexes(&buildResult{Variants: map[string]*variantResult{
+		"jammy-base":         {Release: "v0.4.0", Index: "ghcr.io/gauron99/builder-jammy-base:v0.4.0@" + base},
+		"jammy-base-offline": {Release: "v0.4.0", Index: "ghcr.io/gauron99/builder-jammy-base:v0.4.0-offline@" + offline},
+		"jammy-tiny":         {Release: "v0.4.0"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		in, want string
+		n        int
+	}{
+		{
+			in:   `"ghcr.io/gauron99/builder-jammy-base:latest"`,
+			want: `"ghcr.io/gauron99/builder-jammy-base:v0.4.0@` + base + `"`,
+			n:    1,
+		},
+		{
+			// the tag is bumped to the release, not kept next to the new digest
+			in:   "image: ghcr.io/gauron99/builder-jammy-base:v0.3.0-offline@sha256:" + strings.Repeat("c", 64) + "\n",
+			want: "image: ghcr.io/gauron99/builder-jammy-base:v0.4.0-offline@" + offline + "\n",
+			n:    1,
+		},
+		{
+			in:   "ghcr.io/gauron99/builder-jammy-base:v0.3.0",
+			want: "ghcr.io/gauron99/builder-jammy-base:v0.4.0@" + base,
+			n:    1,
+		},
+		{
+			in:   "ghcr.io/gauron99/builder-jammy-base@" + base,
+			want: "ghcr.io/gauron99/builder-jammy-base@" + base,
+		},
+		{
+			in:   "ghcr.io/gauron99/builder-jammy-base-custom:latest ghcr.io/gauron99/builder-jammy-tiny:latest",
+			want: "ghcr.io/gauron99/builder-jammy-base-custom:latest ghcr.io/gauron99/builder-jammy-tiny:latest",
+		},
+		{
+			in:   "ghcr.io/knative/builder-jammy-base:v0.3.0",
+			want: "ghcr.io/knative/builder-jammy-base:v0.3.0",
+		},
+	} {
+		got, n := rewriteImageRefs(tt.in, indexes, nil)
+		if got != tt.want || n != tt.n {
+			t.Errorf("rewriteImageRefs(%q) = %q, %d, want %q, %d", tt.in, got, n, tt.want, tt.n)
+		}
+	}
+
+	// references in the knative namespace are rewritten to the builders published to gauron99
+	namespaces := map[string]string{"ghcr.io/knative": "ghcr.io/gauron99"}
+	in := "ghcr.io/knative/builder-jammy-base:v0.3.0 ghcr.io/knative/builder-jammy-base:v0.3.0-offline ghcr.io/knative/func-utils:v1"
+	want := "ghcr.io/gauron99/builder-jammy-base:v0.4.0@" + base + " ghcr.io/gauron99/builder-jammy-base:v0.4.0-offline@" + offline + " ghcr.io/knative/func-utils:v1"
+	got, n := rewriteImageRefs(in, indexes, namespaces)
+	if got != want || n != 2 {
+		t.Errorf("rewriteImageRefs(%q) = %q, %d, want %q, 2", in, got, n, want)
+	}
+}
+
+func TestEnsurePR(t *testing.T) {
+	var prs []*github.PullRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const path = "/repos/knative/func/pulls"
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == path:
+			if r.URL.Query().Get("head") != "gauron99:update-builder-images" {
+				t.Errorf("unexpected head %q", r.URL.Query().Get("head"))
+			}
+			_ = json.NewEncoder(w).Encode(prs)
+		case r.Method == http.MethodPost && r.URL.Path == path:
+			var pr github.NewPullRequest
+			_ = json.NewDecoder(r.Body).Decode(&pr)
+			prs = append(prs, &github.PullRequest{Number: github.Ptr(1), Body: pr.Body, Head: &github.PullRequestBranch{Label: pr.Head}})
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(prs[0])
+		case r.Method == http.MethodPatch && r.URL.Path == path+"/1":
+			var pr github.PullRequest
+			_ = json.NewDecoder(r.Body).Decode(&pr)
+			prs[0].Body = pr.Body
+			_ = json.NewEncoder(w).Encode(prs[0])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	d := downstreamRepo{Repo: "knative/func", Fork: "gauron99/func"}
+
+	err := ensurePR(context.Background(), client, d, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 1 || prs[0].GetHead().GetLabel() != "gauron99:update-builder-images" {
+		t.Fatalf("PR not created: %v", prs)
+	}
+
+	err = ensurePR(context.Background(), client, d, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 1 || prs[0].GetBody() != "second" {
+		t.Errorf("PR not updated: %v", prs)
+	}
+}
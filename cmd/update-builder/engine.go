@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pack "github.com/buildpacks/pack/pkg/client"
+	"github.com/buildpacks/pack/pkg/dist"
+	docker "github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Settings of the container engine builders are built with.
+const (
+	// docker or podman, whichever socket is found, daemonless if there is none
+	engineAuto   = "auto"
+	engineDocker = "docker"
+	// podman socket, e.g. of rootless podman enabled by "systemctl --user enable --now podman.socket"
+	enginePodman = "podman"
+	// no daemon, buildpacks and builders are published to the local registry by pack directly
+	engineDaemonless = "daemonless"
+)
+
+// registry images are built into by the daemonless engine
+const localRegistry = "localhost:5000"
+
+// containerEngine hides differences of container engines from the pipeline.
+type containerEngine struct {
+	name string
+	// API socket of the daemon, e.g. "unix:///run/user/1000/podman/podman.sock",
+	// "" for DOCKER_HOST or the default docker socket
+	host string
+}
+
+func newContainerEngine(setting string) (*containerEngine, error) {
+	e, err := detectEngine(setting, os.Getenv, func(path string) bool {
+		fi, err := os.Stat(path)
+		return err == nil && fi.Mode().Type() == os.ModeSocket
+	})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("## container engine: '%v' %v\n", e.name, e.host)
+	if e.daemonless() && setting != engineDaemonless {
+		_, _ = fmt.Fprintf(os.Stderr, "no docker or podman socket found, building daemonless, "+
+			"set engine to %q to build with a daemon or to %q to silence this\n", engineDocker, engineDaemonless)
+	}
+	return e, nil
+}
+
+// returns engine of the setting, sockets are looked up with getenv and socketExists
+func detectEngine(setting string, getenv func(string) string, socketExists func(path string) bool) (*containerEngine, error) {
+	podmanSockets := []string{"/run/podman/podman.sock"}
+	if dir := getenv("XDG_RUNTIME_DIR"); dir != "" {
+		// rootless podman first
+		podmanSockets = append([]string{filepath.Join(dir, "podman", "podman.sock")}, podmanSockets...)
+	}
+	findPodman := func() (*containerEngine, bool) {
+		for _, sock := range podmanSockets {
+			if socketExists(sock) {
+				return &containerEngine{name: enginePodman, host: "unix://" + sock}, true
+			}
+		}
+		return nil, false
+	}
+
+	switch setting {
+	case engineDocker:
+		return &containerEngine{name: engineDocker}, nil
+	case enginePodman:
+		if host := getenv("DOCKER_HOST"); strings.Contains(host, "podman") {
+			return &containerEngine{name: enginePodman, host: host}, nil
+		}
+		if e, ok := findPodman(); ok {
+			return e, nil
+		}
+		return nil, fmt.Errorf("podman socket not found in %s, enable it with \"systemctl --user enable --now podman.socket\"",
+			strings.Join(podmanSockets, ", "))
+	case engineDaemonless:
+		return &containerEngine{name: engineDaemonless}, nil
+	case engineAuto, "":
+		if host := getenv("DOCKER_HOST"); host != "" {
+			if strings.Contains(host, "podman") {
+				return &containerEngine{name: enginePodman, host: host}, nil
+			}
+			return &containerEngine{name: engineDocker}, nil
+		}
+		if socketExists("/var/run/docker.sock") {
+			return &containerEngine{name: engineDocker}, nil
+		}
+		if e, ok := findPodman(); ok {
+			return e, nil
+		}
+		return &containerEngine{name: engineDaemonless}, nil
+	default:
+		return nil, fmt.Errorf("unsupported container engine %q, expected %q, %q, %q or %q",
+			setting, engineAuto, engineDocker, enginePodman, engineDaemonless)
+	}
+}
+
+func (e *containerEngine) daemonless() bool {
+	return e.name == engineDaemonless
+}
+
+// Returns client of the engine API. Docker returns 500 instead of 404 for images that exist only
+// in the daemon, so its client is wrapped by hackDockerClient, podman does not need it.
+func (e *containerEngine) dockerClient(daemonImages *daemonImages, prefixes []string) (docker.APIClient, error) {
+	opts := []docker.Opt{docker.FromEnv, docker.WithAPIVersionNegotiation()}
+	if e.host != "" {
+		opts = append(opts, docker.WithHost(e.host))
+	}
+	cli, err := docker.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create %s client: %w", e.name, err)
+	}
+	if e.name != engineDocker {
+		return cli, nil
+	}
+	return &hackDockerClient{
+		APIClient:    cli,
+		daemonImages: daemonImages,
+		prefixes:     prefixes,
+	}, nil
+}
+
+// returns pack client using the engine and its docker client, the docker client of the daemonless
+// engine is never connected to since pack publishes images directly
+func (e *containerEngine) packClient(daemonImages *daemonImages, prefixes []string) (*pack.Client, docker.APIClient, error) {
+	cli, err := e.dockerClient(daemonImages, prefixes)
+	if err != nil {
+		return nil, nil, err
+	}
+	packClient, err := pack.NewClient(pack.WithKeychain(DefaultKeychain), pack.WithDockerClient(cli))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create pack client: %w", err)
+	}
+	return packClient, cli, nil
+}
+
+// Returns targets images of the arch are built for. Pack builds an index of all targets when it
+// publishes more than one, so the daemonless engine builds only the arch target to get an image of the arch.
+func (e *containerEngine) targets(arch string) []dist.Target {
+	if e.daemonless() {
+		return []dist.Target{{OS: "linux", Arch: arch}}
+	}
+	return []dist.Target{
+		{
+			OS:   "linux",
+			Arch: arch,
+		},
+		{OS: "linux"},
+	}
+}
+
+// returns reference the buildpack image is built as, the daemonless engine
+// publishes it to the local registry instead of the daemon
+func (e *containerEngine) buildpackImage(img string) string {
+	if !e.daemonless() {
+		return img
+	}
+	ref, err := name.ParseReference(img)
+	if err != nil {
+		return img
+	}
+	return localRegistry + "/" + ref.Context().RepositoryStr() + ":" + ref.Identifier()
+}
+
+// returns architecture of the image built by the engine
+func (e *containerEngine) imageArch(ctx context.Context, img string, remoteOpts ...remote.Option) (string, error) {
+	if e.daemonless() {
+		ref, err := name.ParseReference(img)
+		if err != nil {
+			return "", err
+		}
+		i, err := remote.Image(ref, remoteOpts...)
+		if err != nil {
+			return "", err
+		}
+		cf, err := i.ConfigFile()
+		if err != nil {
+			return "", err
+		}
+		return cf.Architecture, nil
+	}
+
+	cli, err := e.dockerClient(nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer func(cli docker.APIClient) {
+		_ = cli.Close()
+	}(cli)
+	resp, err := cli.ImageInspect(ctx, img)
+	if err != nil {
+		return "", err
+	}
+	return resp.Architecture, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/buildpacks/pack/buildpackage"
+	pack "github.com/buildpacks/pack/pkg/client"
+	"github.com/buildpacks/pack/pkg/dist"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestDetectEngine(t *testing.T) {
+	const (
+		dockerSock         = "/var/run/docker.sock"
+		rootlessPodmanSock = "/run/user/1000/podman/podman.sock"
+		rootfulPodmanSock  = "/run/podman/podman.sock"
+	)
+	tests := []struct {
+		name      string
+		setting   string
+		env       map[string]string
+		sockets   []string
+		want      containerEngine
+		wantError bool
+	}{
+		{name: "auto docker", setting: engineAuto, sockets: []string{dockerSock, rootlessPodmanSock}, want: containerEngine{name: engineDocker}},
+		{name: "auto docker host", setting: engineAuto, env: map[string]string{"DOCKER_HOST": "tcp://127.0.0.1:2375"}, sockets: []string{rootlessPodmanSock}, want: containerEngine{name: engineDocker}},
+		{name: "auto podman host", setting: engineAuto, env: map[string]string{"DOCKER_HOST": "unix:///run/user/1000/podman/podman.sock"}, want: containerEngine{name: enginePodman, host: "unix:///run/user/1000/podman/podman.sock"}},
+		{name: "auto rootless podman", setting: engineAuto, env: map[string]string{"XDG_RUNTIME_DIR": "/run/user/1000"}, sockets: []string{rootlessPodmanSock, rootfulPodmanSock}, want: containerEngine{name: enginePodman, host: "unix://" + rootlessPodmanSock}},
+		{name: "auto rootful podman", setting: "", sockets: []string{rootfulPodmanSock}, want: containerEngine{name: enginePodman, host: "unix://" + rootfulPodmanSock}},
+		{name: "auto daemonless", setting: engineAuto, want: containerEngine{name: engineDaemonless}},
+		{name: "podman", setting: enginePodman, env: map[string]string{"XDG_RUNTIME_DIR": "/run/user/1000"}, sockets: []string{dockerSock, rootlessPodmanSock}, want: containerEngine{name: enginePodman, host: "unix://" + rootlessPodmanSock}},
+		{name: "podman without socket", setting: enginePodman, sockets: []string{dockerSock}, wantError: true},
+		{name: "docker", setting: engineDocker, sockets: []string{rootlessPodmanSock}, want: containerEngine{name: engineDocker}},
+		{name: "unknown", setting: "containerd", wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := detectEngine(tt.setting, func(key string) string {
+				return tt.env[key]
+			}, func(path string) bool {
+				return slices.Contains(tt.sockets, path)
+			})
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("expected error, got %+v", e)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *e != tt.want {
+				t.Errorf("engine = %+v, want %+v", *e, tt.want)
+			}
+		})
+	}
+}
+
+func TestDaemonlessEngine(t *testing.T) {
+	e := &containerEngine{name: engineDaemonless}
+	got := e.buildpackImage("ghcr.io/gauron99/buildpacks/java:18.9.0-offline")
+	if got != "localhost:5000/gauron99/buildpacks/java:18.9.0-offline" {
+		t.Errorf("buildpack image = %s", got)
+	}
+	docker := &containerEngine{name: engineDocker}
+	if got := docker.buildpackImage("ghcr.io/gauron99/buildpacks/java:18.9.0"); got != "ghcr.io/gauron99/buildpacks/java:18.9.0" {
+		t.Errorf("buildpack image of docker engine = %s", got)
+	}
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf = cf.DeepCopy()
+	cf.Architecture = "arm64"
+	img, err = mutate.ConfigFile(img, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := pushTestImage(t, testRegistry(t)+"/buildpacks/java:18.9.0", img)
+
+	arch, err := e.imageArch(context.Background(), ref.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arch != "arm64" {
+		t.Errorf("arch = %s", arch)
+	}
+}
+
+func TestDaemonlessPackageBuildpack(t *testing.T) {
+	reg := testRegistry(t)
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"buildpack.toml": "api = \"0.10\"\n\n[buildpack]\nid = \"test/buildpack\"\nversion = \"1.0.0\"\n\n[[targets]]\nos = \"linux\"\narch = \"arm64\"\n",
+		"bin/detect":     "#!/bin/sh\n",
+		"bin/build":      "#!/bin/sh\n",
+	}
+	for file, content := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := &containerEngine{name: engineDaemonless}
+	packClient, _, err := e.packClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := reg + "/buildpacks/test:1.0.0-arm64"
+	err = packClient.PackageBuildpack(context.Background(), pack.PackageBuildpackOptions{
+		RelativeBaseDir: dir,
+		Name:            img,
+		Format:          pack.FormatImage,
+		Config:          buildpackage.Config{Buildpack: dist.BuildpackURI{URI: "."}},
+		Publish:         true,
+		Targets:         e.targets("arm64"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tag has to be a single image of the arch, not an index resolved to the default platform
+	ref, err := name.ParseReference(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := remote.Get(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.MediaType.IsIndex() {
+		t.Fatalf("%s is an index", img)
+	}
+	arch, err := e.imageArch(context.Background(), img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arch != "arm64" {
+		t.Errorf("arch = %s", arch)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildpacks/pack/pkg/dist"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// starts in-process registry closed when the test ends and returns its host
+func testRegistry(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(registry.New())
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+// pushes img to ref, random image if img is nil
+func pushTestImage(t *testing.T, ref string, img v1.Image) name.Reference {
+	t.Helper()
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img == nil {
+		img, err = random.Image(64, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = remote.Write(r, img); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// pushes idx to ref, random index if idx is nil
+func pushTestIndex(t *testing.T, ref string, idx v1.ImageIndex) name.Reference {
+	t.Helper()
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx == nil {
+		idx, err = random.Index(64, 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = remote.WriteIndex(r, idx); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func builderImage(t *testing.T, bl builderLabels) v1.Image {
+	t.Helper()
+	return variantBuilderImage(t, "base", bl)
+}
+
+// returns jammy builder image of the variant with given labels
+func variantBuilderImage(t *testing.T, variant string, bl builderLabels) v1.Image {
+	t.Helper()
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := make(map[string]string)
+	for label, val := range map[string]any{
+		builderMetadataLabel:      bl.Metadata,
+		buildpackOrderLabel:       bl.Order,
+		dist.BuildpackLayersLabel: bl.Layers,
+	} {
+		bs, err := json.Marshal(val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		labels[label] = string(bs)
+	}
+	maps.Copy(labels, testOCILabels(variant))
+	img, err = mutate.Config(img, v1.Config{Labels: labels})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// returns OCI labels of jammy builder of the variant expanded from the default templates
+func testOCILabels(variant string) map[string]string {
+	vars := labelVars{distro: distroJammy, variant: variant, version: "v0.4.0", created: time.Unix(1700000000, 0)}
+	return vars.expand(defaultLabels())
+}
+
+// returns labels of a builder built with the default config
+func testBuilderLabels(cfg config) builderLabels {
+	javaOrder := dist.Order{{Group: []dist.ModuleRef{
+		{ModuleInfo: dist.ModuleInfo{ID: "paketo-buildpacks/quarkus"}},
+		{ModuleInfo: dist.ModuleInfo{ID: "paketo-buildpacks/maven"}},
+	}}}
+	bl := builderLabels{
+		Order: dist.Order{
+			{Group: []dist.ModuleRef{{ModuleInfo: dist.ModuleInfo{ID: "paketo-community/rust"}}}},
+			{Group: []dist.ModuleRef{{ModuleInfo: dist.ModuleInfo{ID: "paketo-buildpacks/java"}}}},
+		},
+		Layers: dist.ModuleLayers{
+			"paketo-community/rust":               {"0.65.0": {}},
+			"paketo-buildpacks/java":              {"18.9.0": {Order: javaOrder}},
+			"paketo-buildpacks/java-native-image": {"11.1.0": {Order: javaOrder}},
+		},
+	}
+	bl.Metadata.Description = "Paketo Jammy builder.\n" + cfg.Buildpacks[0].Description
+	return bl
+}
+
+// returns labels of a tiny builder built with the default config, it has java-native-image but no java composite
+func testTinyBuilderLabels(cfg config) builderLabels {
+	bl := testBuilderLabels(cfg)
+	bl.Order = dist.Order{
+		{Group: []dist.ModuleRef{{ModuleInfo: dist.ModuleInfo{ID: "paketo-community/rust"}}}},
+		{Group: []dist.ModuleRef{{ModuleInfo: dist.ModuleInfo{ID: "paketo-buildpacks/java-native-image"}}}},
+	}
+	bl.Layers = maps.Clone(bl.Layers)
+	delete(bl.Layers, "paketo-buildpacks/java")
+	return bl
+}
+
+// returns index with builder image of given labels for each arch (arch -> labels)
+func testBuilderIndex(t *testing.T, arches map[string]builderLabels) v1.ImageIndex {
+	t.Helper()
+	return testVariantIndex(t, "base", arches)
+}
+
+// returns index of jammy builder of the variant with builder image of given labels for each arch
+func testVariantIndex(t *testing.T, variant string, arches map[string]builderLabels) v1.ImageIndex {
+	t.Helper()
+	idx := mutate.IndexMediaType(empty.Index, types.DockerManifestList)
+	idx = mutate.Annotations(idx, testOCILabels(variant)).(v1.ImageIndex)
+	for arch, bl := range arches {
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add: variantBuilderImage(t, variant, bl),
+			Descriptor: v1.Descriptor{
+				MediaType: types.DockerManifestSchema2,
+				Platform:  &v1.Platform{OS: "linux", Architecture: arch},
+			},
+		})
+	}
+	return idx
+}
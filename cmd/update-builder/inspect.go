@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/buildpacks/pack/pkg/dist"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// inspectReport is the result of checking a published builder index against the configuration it was built from.
+type inspectReport struct {
+	ref    string
+	checks []inspectCheck
+}
+
+type inspectCheck struct {
+	name string
+	// nil when the check passed
+	err error
+}
+
+func (r *inspectReport) add(name string, err error) {
+	r.checks = append(r.checks, inspectCheck{name: name, err: err})
+}
+
+func (r *inspectReport) failed() bool {
+	return slices.ContainsFunc(r.checks, func(c inspectCheck) bool {
+		return c.err != nil
+	})
+}
+
+func (r *inspectReport) print(w io.Writer) {
+	_, _ = fmt.Fprintln(w, r.ref)
+	for _, c := range r.checks {
+		if c.err != nil {
+			_, _ = fmt.Fprintf(w, "  FAIL  %s: %v\n", c.name, c.err)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "  ok    %s\n", c.name)
+	}
+}
+
+// checks that the builder index contains all arches of the variant and that builders
+// of all arches contain the injected buildpacks and patched composite buildpacks of cfg,
+// and the configured labels and annotations
+func inspectBuilder(cfg *config, ref name.Reference, remoteOpts ...remote.Option) (*inspectReport, error) {
+	report := &inspectReport{ref: ref.String()}
+	distro, variant, ok := parseBuilderName(ref.Context().RepositoryStr())
+	if !ok {
+		return nil, fmt.Errorf("%q is not a builder repository", ref.Context())
+	}
+
+	idx, err := remote.Index(ref, remoteOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get image index: %w", err)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get index manifest: %w", err)
+	}
+
+	mediaType, err := indexMediaType(cfg.IndexMediaType)
+	if err != nil {
+		return nil, err
+	}
+	if im.MediaType != mediaType {
+		report.add("media type", fmt.Errorf("index is %s, expected %s", im.MediaType, mediaType))
+	} else {
+		report.add("media type", nil)
+	}
+	report.add("annotations", checkLabels(cfg.Annotations, distro, variant, "", im.Annotations))
+
+	manifests := make(map[string]v1.Hash)
+	for _, desc := range im.Manifests {
+		if desc.Platform == nil || desc.Platform.OS != "linux" {
+			continue
+		}
+		manifests[desc.Platform.Architecture] = desc.Digest
+	}
+	var missing []string
+	for _, arch := range builderArches(variant) {
+		if _, ok := manifests[arch]; !ok {
+			missing = append(missing, "linux/"+arch)
+		}
+	}
+	if len(missing) > 0 {
+		report.add("platforms", fmt.Errorf("missing %s", strings.Join(missing, ", ")))
+	} else {
+		report.add("platforms", nil)
+	}
+
+	archLabels := make(map[string]builderLabels)
+	for _, arch := range builderArches(variant) {
+		digest, ok := manifests[arch]
+		if !ok {
+			continue
+		}
+		img, err := idx.Image(digest)
+		if err == nil {
+			_, err = img.Manifest()
+		}
+		report.add(arch+": manifest", err)
+		if err != nil {
+			continue
+		}
+		cf, err := img.ConfigFile()
+		if err == nil {
+			err = checkLabels(cfg.Labels, distro, variant, arch, cf.Config.Labels)
+		}
+		report.add(arch+": OCI labels", err)
+		bl, err := readBuilderLabels(img)
+		report.add(arch+": labels", err)
+		if err != nil {
+			continue
+		}
+		archLabels[arch] = bl
+		report.add(arch+": injected buildpacks", checkInjectedBuildpacks(cfg.Buildpacks, bl))
+		report.add(arch+": composite buildpacks", checkPatchedComposites(cfg.Patches, bl))
+	}
+	if len(archLabels) > 1 {
+		report.add("arches agree", checkManifestsAgree(archLabels))
+	}
+	return report, nil
+}
+
+// checks that injected buildpacks are in the builder, each in its own group before upstream groups
+func checkInjectedBuildpacks(buildpacks []injectedBuildpack, bl builderLabels) error {
+	for i, bp := range buildpacks {
+		if _, ok := bl.Layers[bp.ID]; !ok {
+			return fmt.Errorf("%q is missing", bp.ID)
+		}
+		if i >= len(bl.Order) || !slices.ContainsFunc(bl.Order[i].Group, func(ref dist.ModuleRef) bool {
+			return ref.ID == bp.ID
+		}) {
+			return fmt.Errorf("%q is not in order group %d", bp.ID, i)
+		}
+		if bp.Description != "" && !strings.Contains(bl.Metadata.Description, bp.Description) {
+			return fmt.Errorf("description does not mention %q", bp.ID)
+		}
+	}
+	return nil
+}
+
+// Checks that modules inserted into composite buildpacks are in their order and removed ones are not.
+// Composites not in the order of the builder are not patched, e.g. java in the tiny builder, they are skipped.
+func checkPatchedComposites(patches []compositePatch, bl builderLabels) error {
+	for _, patch := range patches {
+		for _, id := range patch.Buildpacks {
+			if !orderContains(bl.Order, id) {
+				continue
+			}
+			versions, ok := bl.Layers[id]
+			if !ok {
+				return fmt.Errorf("%q is missing", id)
+			}
+			for version, info := range versions {
+				for _, op := range patch.Operations {
+					var want string
+					present := true
+					switch op.Op {
+					case opInsertBefore, opInsertAfter, opReplace:
+						want = op.Module.ID
+					case opRemove:
+						want, present = op.Target, false
+					default:
+						continue
+					}
+					if orderContains(info.Order, want) != present {
+						if present {
+							return fmt.Errorf("%q is not in the order of %s@%s", want, id, version)
+						}
+						return fmt.Errorf("%q is still in the order of %s@%s", want, id, version)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func orderContains(order dist.Order, id string) bool {
+	return slices.ContainsFunc(order, func(entry dist.OrderEntry) bool {
+		return slices.ContainsFunc(entry.Group, func(ref dist.ModuleRef) bool {
+			return ref.ID == id
+		})
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/buildpacks/pack/pkg/dist"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestInspectBuilder(t *testing.T) {
+	reg := testRegistry(t)
+
+	cfg := defaultConfig()
+	good := testBuilderLabels(cfg)
+	noQuarkus := good
+	noQuarkus.Layers = maps.Clone(good.Layers)
+	noQuarkus.Layers["paketo-buildpacks/java"] = map[string]dist.ModuleLayerInfo{"18.9.0": {}}
+	tiny := testTinyBuilderLabels(cfg)
+	tinyNoQuarkus := tiny
+	tinyNoQuarkus.Layers = maps.Clone(tiny.Layers)
+	tinyNoQuarkus.Layers["paketo-buildpacks/java-native-image"] = map[string]dist.ModuleLayerInfo{"11.1.0": {}}
+
+	push := func(tag string, arches map[string]builderLabels) name.Reference {
+		return pushTestIndex(t, reg+"/gauron99/builder-jammy-base:"+tag, testBuilderIndex(t, arches))
+	}
+
+	for _, tt := range []struct {
+		name   string
+		arches map[string]builderLabels
+		fail   string
+	}{
+		{name: "ok", arches: map[string]builderLabels{"amd64": good, "arm64": good}},
+		{name: "missing-arch", arches: map[string]builderLabels{"amd64": good}, fail: "platforms"},
+		{name: "no-quarkus", arches: map[string]builderLabels{"amd64": good, "arm64": noQuarkus}, fail: "arm64: composite buildpacks"},
+		{name: "tiny", arches: map[string]builderLabels{"amd64": tiny, "arm64": tiny}},
+		{name: "tiny-no-quarkus", arches: map[string]builderLabels{"amd64": tiny, "arm64": tinyNoQuarkus}, fail: "arm64: composite buildpacks"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := inspectBuilder(&cfg, push(tt.name, tt.arches))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var failed []string
+			for _, c := range report.checks {
+				if c.err != nil {
+					failed = append(failed, c.name)
+				}
+			}
+			if tt.fail == "" && len(failed) > 0 {
+				t.Errorf("unexpected failed checks: %v", failed)
+			}
+			if tt.fail != "" && (len(failed) == 0 || failed[0] != tt.fail) {
+				t.Errorf("expected %q check to fail, failed: %v", tt.fail, failed)
+			}
+		})
+	}
+}
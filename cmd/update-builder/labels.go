@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OCI annotations set automatically on builder images and indexes,
+// see https://github.com/opencontainers/image-spec/blob/main/annotations.md
+const (
+	ociCreated  = "org.opencontainers.image.created"
+	ociRevision = "org.opencontainers.image.revision"
+	ociBaseName = "org.opencontainers.image.base.name"
+)
+
+// placeholders substituted in configured labels and annotations
+var labelPlaceholders = []string{"{distro}", "{distroTitle}", "{variant}", "{profile}", "{builder}", "{version}", "{arch}", "{sha}"}
+
+var placeholderRegex = regexp.MustCompile(`\{[A-Za-z]+\}`)
+
+// labelVars are values labels of a builder image or annotations of a builder index are created from.
+type labelVars struct {
+	distro  string
+	variant string
+	profile *buildProfile
+	// upstream release
+	version string
+	// "" for the index
+	arch string
+	// commit of this repository the builder is built from, "" when unknown
+	sha     string
+	created time.Time
+	// upstream build image the builder is based on
+	baseImage string
+}
+
+// returns labels of the templates with placeholders substituted, automatic OCI labels are
+// added unless the templates set them. Labels with empty value are not set.
+func (v labelVars) expand(templates map[string]string) map[string]string {
+	r := strings.NewReplacer(
+		"{distro}", v.distro,
+		"{distroTitle}", distroTitle(v.distro),
+		"{variant}", v.variant,
+		"{profile}", strings.TrimPrefix(v.profile.tagSuffix(), "-"),
+		"{builder}", builderName(v.distro, v.variant),
+		"{version}", v.version,
+		"{arch}", v.arch,
+		"{sha}", v.sha,
+	)
+	labels := map[string]string{
+		ociCreated:  v.created.UTC().Format(time.RFC3339),
+		ociRevision: v.sha,
+		ociBaseName: v.baseImage,
+	}
+	for key, tmpl := range templates {
+		labels[key] = r.Replace(tmpl)
+	}
+	for key, val := range labels {
+		if val == "" {
+			delete(labels, key)
+		}
+	}
+	return labels
+}
+
+// checks that the templates contain only known placeholders
+func checkLabelTemplates(templates map[string]string) error {
+	for key, tmpl := range templates {
+		for _, p := range placeholderRegex.FindAllString(tmpl, -1) {
+			if !slices.Contains(labelPlaceholders, p) {
+				return fmt.Errorf("unknown placeholder %s in %q", p, key)
+			}
+		}
+	}
+	return nil
+}
+
+// Checks that labels of the templates are set on the image or index of the builder. Only placeholders known
+// from the builder repository and the arch are substituted, values of templates with other placeholders are
+// not compared. Templates with {sha} or {profile} may expand to empty value that is not set, they are not required.
+func checkLabels(templates map[string]string, distro, variant, arch string, got map[string]string) error {
+	r := strings.NewReplacer(
+		"{distro}", distro,
+		"{distroTitle}", distroTitle(distro),
+		"{variant}", variant,
+		"{builder}", builderName(distro, variant),
+		"{arch}", arch,
+	)
+	var problems []string
+	if got[ociCreated] == "" {
+		problems = append(problems, ociCreated+" is missing")
+	}
+	for _, key := range slices.Sorted(maps.Keys(templates)) {
+		tmpl := templates[key]
+		if tmpl == "" || strings.Contains(tmpl, "{sha}") || strings.Contains(tmpl, "{profile}") {
+			continue
+		}
+		val, ok := got[key]
+		want := r.Replace(tmpl)
+		switch {
+		case !ok:
+			problems = append(problems, key+" is missing")
+		case !placeholderRegex.MatchString(want) && val != want:
+			problems = append(problems, fmt.Sprintf("%s is %q, expected %q", key, val, want))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// default labels of builder images and annotations of builder indexes
+func defaultLabels() map[string]string {
+	return map[string]string{
+		"org.opencontainers.image.description": "Paketo {distroTitle} builder enriched with Rust and Quarkus buildpack.",
+		"org.opencontainers.image.source":      "https://github.com/gauron99/actions-testing",
+		"org.opencontainers.image.vendor":      "https://github.com/gauron99",
+		"org.opencontainers.image.url":         "https://github.com/gauron99/actions-testing/pkgs/container/{builder}",
+		"org.opencontainers.image.version":     "{version}",
+	}
+}
+
+// returns commit the builder is built from, GITHUB_SHA in workflows, HEAD of the working directory otherwise
+func gitRevision(ctx context.Context) string {
+	if sha := os.Getenv("GITHUB_SHA"); sha != "" {
+		return sha
+	}
+	out, err := exec.CommandContext(ctx, "git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
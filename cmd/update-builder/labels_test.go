@@ -0,0 +1,110 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLabelVarsExpand(t *testing.T) {
+	v := labelVars{
+		distro:    distroJammy,
+		variant:   "base",
+		profile:   &buildProfile{Name: "offline"},
+		version:   "v0.4.0",
+		arch:      "arm64",
+		sha:       "0123abc",
+		created:   time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		baseImage: "docker.io/paketobuildpacks/build-jammy-base",
+	}
+	got := v.expand(map[string]string{
+		"org.opencontainers.image.title":   "{builder} {distroTitle} {profile} {arch}",
+		"org.opencontainers.image.version": "{version}",
+		"org.example.commit":               "{sha}",
+		ociBaseName:                        "",
+	})
+	want := map[string]string{
+		"org.opencontainers.image.title":   "builder-jammy-base Jammy offline arm64",
+		"org.opencontainers.image.version": "v0.4.0",
+		"org.example.commit":               "0123abc",
+		ociCreated:                         "2024-05-06T07:08:09Z",
+		ociRevision:                        "0123abc",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("labels = %v, want %v", got, want)
+	}
+
+	// the index has no arch and unknown revision is not set
+	got = labelVars{distro: distroJammy, variant: "base", created: v.created}.expand(map[string]string{
+		"org.opencontainers.image.title": "{builder}{arch}",
+	})
+	want = map[string]string{
+		"org.opencontainers.image.title": "builder-jammy-base",
+		ociCreated:                       "2024-05-06T07:08:09Z",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("labels = %v, want %v", got, want)
+	}
+}
+
+func TestLoadConfigLabels(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "config.json")
+		err := os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cfg, err := loadConfig(write(`{"labels": {"org.opencontainers.image.vendor": "", "org.example.variant": "{variant}"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Labels["org.opencontainers.image.version"] != "{version}" {
+		t.Errorf("default label was dropped: %v", cfg.Labels)
+	}
+	if cfg.Annotations["org.opencontainers.image.vendor"] == "" {
+		t.Error("labels changed annotations")
+	}
+	labels := labelVars{distro: distroJammy, variant: "tiny"}.expand(cfg.Labels)
+	if _, ok := labels["org.opencontainers.image.vendor"]; ok {
+		t.Error("label with empty value is set")
+	}
+	if labels["org.example.variant"] != "tiny" {
+		t.Errorf("labels = %v", labels)
+	}
+
+	_, err = loadConfig(write(`{"annotations": {"org.example.commit": "{commit}"}}`))
+	if err == nil {
+		t.Error("expected error for unknown placeholder")
+	}
+}
+
+func TestCheckLabels(t *testing.T) {
+	templates := map[string]string{
+		"org.opencontainers.image.url":      "https://github.com/gauron99/actions-testing/pkgs/container/{builder}",
+		"org.opencontainers.image.version":  "{version}",
+		"org.opencontainers.image.revision": "{sha}",
+	}
+	vars := labelVars{distro: distroJammy, variant: "base", version: "v0.4.0", created: time.Now()}
+	good := vars.expand(templates)
+	if err := checkLabels(templates, distroJammy, "base", "amd64", good); err != nil {
+		t.Error(err)
+	}
+
+	wrongURL := maps.Clone(good)
+	wrongURL["org.opencontainers.image.url"] = "https://github.com/gauron99/actions-testing/pkgs/container/builder-noble-base"
+	noVersion := maps.Clone(good)
+	delete(noVersion, "org.opencontainers.image.version")
+	noCreated := maps.Clone(good)
+	delete(noCreated, ociCreated)
+	for name, labels := range map[string]map[string]string{"wrong url": wrongURL, "no version": noVersion, "no created": noCreated} {
+		if err := checkLabels(templates, distroJammy, "base", "amd64", labels); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
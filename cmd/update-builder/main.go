@@ -4,11 +4,14 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"os"
 	"os/exec"
@@ -18,6 +21,7 @@ import (
 	"slices"
 	"strings"
 	"syscall"
+	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/term"
@@ -32,8 +36,6 @@ import (
 	"github.com/docker/docker/api/types/registry"
 	docker "github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/jsonmessage"
-	"github.com/google/go-containerregistry/pkg/authn"
-	ghAuth "github.com/google/go-containerregistry/pkg/authn/github"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
@@ -47,6 +49,15 @@ import (
 	"github.com/pelletier/go-toml"
 )
 
+// subcommands of the tool, build is run when none is given
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"build":   runBuild,
+	"diff":    runDiff,
+	"doctor":  runDoctor,
+	"inspect": runInspect,
+	"verify":  runVerify,
+}
+
 func main() {
 	// Set up context for possible signal inputs to not disrupt cleanup process.
 	// This is not gonna do much for workflows since they finish and shutdown
@@ -62,34 +73,245 @@ func main() {
 		os.Exit(130)
 	}()
 
+	cmd, args := "build", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	run, ok := commands[cmd]
+	if !ok {
+		_, _ = fmt.Fprintf(os.Stderr, "unknown command %q\n", cmd)
+		os.Exit(2)
+	}
+	err := run(ctx, args)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// builds and publishes builders of all configured distributions and variants
+func runBuild(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to JSON configuration file")
+	resultPath := fs.String("result", "", "path the JSON manifest of the build result is written to")
+	statePath := fs.String("state", "", "path the checkpoint of finished stages is written to")
+	resume := fs.Bool("resume", false, "skip stages finished by the previous run, requires -state")
+	skipDoctor := fs.Bool("skip-doctor", false, "do not check the environment before the build")
+	_ = fs.Parse(args)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	retryPolicies = cfg.Retry
+	DefaultKeychain = newRegistryKeychain(cfg.Registries)
+	engine, err := newContainerEngine(cfg.Engine)
+	if err != nil {
+		return err
+	}
+	if !*skipDoctor {
+		report := doctor(ctx, &cfg, engine)
+		report.print(os.Stdout)
+		if report.failed() {
+			return fmt.Errorf("environment is not ready to build, fix the failed checks or run with -skip-doctor")
+		}
+	}
+	cp, err := loadCheckpoint(*statePath, *resume, &cfg)
+	if err != nil {
+		return err
+	}
+
 	var hadError bool
-	//for _, variant := range []string{"tiny", "base", "full"} {
-	//for _, variant := range []string{"tiny", "base"} {
-	for _, variant := range []string{"base"} {
-		fmt.Println("::group::" + variant)
-		err := buildBuilderImageMultiArch(ctx, variant)
+	var result buildResult
+	profiles := []*buildProfile{nil}
+	if cfg.Offline != nil {
+		profiles = append(profiles, cfg.Offline)
+	}
+	for _, distro := range cfg.Distributions {
+		for _, variant := range cfg.Variants {
+			for _, profile := range profiles {
+				key := variantKey(distro, variant, profile)
+				fmt.Println("::group::" + key)
+				err := buildBuilderImageMultiArch(ctx, &cfg, cp, engine, distro, variant, profile, result.variant(key))
+				if err != nil {
+					_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+					hadError = true
+				}
+				fmt.Println("::endgroup::")
+			}
+		}
+	}
+	if len(cfg.Downstream) > 0 && !hadError {
+		indexes, err := publishedIndexes(&result)
+		if err != nil {
+			return err
+		}
+		for _, d := range cfg.Downstream {
+			err = updateDownstream(ctx, newGHClient(ctx), d, indexes)
+			if err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "ERROR: cannot update %s: %v\n", d.Repo, err)
+				hadError = true
+			}
+		}
+	}
+	if *resultPath != "" {
+		err = result.write(*resultPath)
 		if err != nil {
 			_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 			hadError = true
 		}
-		fmt.Println("::endgroup::")
 	}
 	if hadError {
-		fmt.Fprintln(os.Stderr, "failed to update builder")
-		os.Exit(1)
+		return fmt.Errorf("failed to update builder")
+	}
+	return nil
+}
+
+// checks that the environment is ready to build the configured builders
+func runDoctor(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to JSON configuration file")
+	_ = fs.Parse(args)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	retryPolicies = cfg.Retry
+	DefaultKeychain = newRegistryKeychain(cfg.Registries)
+	engine, err := newContainerEngine(cfg.Engine)
+	if err != nil {
+		return err
+	}
+
+	report := doctor(ctx, &cfg, engine)
+	report.print(os.Stdout)
+	if report.failed() {
+		return fmt.Errorf("environment is not ready to build")
+	}
+	return nil
+}
+
+// verifies signatures of images given as arguments
+func runVerify(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyPath := fs.String("key", "", "path to PEM encoded public key the images are signed with")
+	configPath := fs.String("config", "", "path to JSON configuration file with registry credentials and retry policies")
+	_ = fs.Parse(args)
+	if *keyPath == "" || fs.NArg() == 0 {
+		return fmt.Errorf("usage: verify -key <public key> [-config <config>] <image>...")
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	retryPolicies = cfg.Retry
+	DefaultKeychain = newRegistryKeychain(cfg.Registries)
+
+	key, err := loadVerificationKey(*keyPath)
+	if err != nil {
+		return err
+	}
+
+	var hadError bool
+	for _, img := range fs.Args() {
+		ref, err := name.ParseReference(img)
+		if err != nil {
+			return fmt.Errorf("cannot parse image ref: %w", err)
+		}
+		err = verifySignatures(key, ref, registryOptions(ctx)...)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "ERROR: %s: %v\n", img, err)
+			hadError = true
+			continue
+		}
+		fmt.Printf("%s: signatures verified\n", img)
+	}
+	if hadError {
+		return fmt.Errorf("verification failed")
+	}
+	return nil
+}
+
+// checks published builders given as arguments against the configuration
+func runInspect(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to JSON configuration file the builders were built with")
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: inspect [-config <config>] <image>...")
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	retryPolicies = cfg.Retry
+	DefaultKeychain = newRegistryKeychain(cfg.Registries)
+
+	var hadError bool
+	for _, img := range fs.Args() {
+		ref, err := name.ParseReference(img)
+		if err != nil {
+			return fmt.Errorf("cannot parse image ref: %w", err)
+		}
+		report, err := inspectBuilder(&cfg, ref, registryOptions(ctx)...)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "ERROR: %s: %v\n", img, err)
+			hadError = true
+			continue
+		}
+		report.print(os.Stdout)
+		hadError = hadError || report.failed()
+	}
+	if hadError {
+		return fmt.Errorf("inspection failed")
+	}
+	return nil
+}
+
+// prints Markdown describing changes between two published builders
+func runDiff(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to JSON configuration file with registry credentials and retry policies")
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: diff [-config <config>] <old image> <new image>")
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	retryPolicies = cfg.Retry
+	DefaultKeychain = newRegistryKeychain(cfg.Registries)
+
+	var summaries [2]builderSummary
+	for i, img := range fs.Args() {
+		ref, err := name.ParseReference(img)
+		if err != nil {
+			return fmt.Errorf("cannot parse image ref: %w", err)
+		}
+		summaries[i], err = summarizeBuilder(ref, registryOptions(ctx)...)
+		if err != nil {
+			return err
+		}
 	}
+	fmt.Print(diffBuilders(summaries[0], summaries[1]))
+	return nil
 }
 
-func buildBuilderImage(ctx context.Context, variant, version, arch, builderTomlPath string) (string, error) {
+func buildBuilderImage(ctx context.Context, cfg *config, st *variantState, distro, variant, version, arch, builderTomlPath string) (string, error) {
 	fmt.Print("#### buildBuilderImage\n")
-	newBuilderImage := "localhost:5000/knative/builder-jammy-" + variant
-	newBuilderImageTagged := newBuilderImage + ":" + version + "-" + arch
+	newBuilderImage := "localhost:5000/knative/" + builderName(distro, variant)
+	newBuilderImageTagged := newBuilderImage + ":" + version + st.profile.tagSuffix() + "-" + arch
 
 	ref, err := name.ParseReference(newBuilderImageTagged)
 	if err != nil {
 		return "", fmt.Errorf("cannot parse reference to builder target: %w", err)
 	}
-	desc, err := remote.Head(ref, remote.WithAuthFromKeychain(DefaultKeychain), remote.WithContext(ctx))
+	desc, err := remote.Head(ref, registryOptions(ctx)...)
 	if err == nil {
 		fmt.Fprintln(os.Stderr, "The image has been already built.")
 		return newBuilderImage + "@" + desc.Digest.String(), nil
@@ -100,52 +322,66 @@ func buildBuilderImage(ctx context.Context, variant, version, arch, builderTomlP
 		return "", fmt.Errorf("cannot parse builder.toml: %w", err)
 	}
 
+	baseImage := builderConfig.Stack.BuildImage
 	// this is just copy
-	fixupStacks(&builderConfig)
-	err = updateJavaBuildpacks(ctx, &builderConfig, arch)
+	err = fixupStacks(&builderConfig, st.stackMirror)
 	if err != nil {
-		return "", fmt.Errorf("cannot patch java buildpacks: %w", err)
+		return "", fmt.Errorf("cannot fixup stacks: %w", err)
 	}
-	addRustBuildpacks(&builderConfig)
-
-	var dockerClient docker.APIClient
-	dockerClient, err = docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	localImages, err := patchCompositeBuildpacks(ctx, cfg, st, &builderConfig, arch)
 	if err != nil {
-		return "", fmt.Errorf("cannot create docker client")
+		return "", fmt.Errorf("cannot patch composite buildpacks: %w", err)
+	}
+	addBuildpacks(&builderConfig, cfg.Buildpacks, st.versions)
+
+	if st.profile != nil {
+		offlineImages, err := st.offlineBuilderConfig(ctx, &builderConfig, arch)
+		if err != nil {
+			return "", fmt.Errorf("cannot build buildpacks of %q profile: %w", st.profile.Name, err)
+		}
+		localImages = append(localImages, offlineImages...)
+	}
+
+	if st.relocator != nil {
+		err = st.relocator.relocateBuilderConfig(ctx, &builderConfig, localImages)
+		if err != nil {
+			return "", fmt.Errorf("cannot relocate builder images: %w", err)
+		}
+	}
+	if st.pinner != nil {
+		err = st.pinner.pinBuilderConfig(&builderConfig, localImages)
+		if err != nil {
+			return "", fmt.Errorf("cannot pin builder images to digests: %w", err)
+		}
 	}
-	dockerClient = &hackDockerClient{dockerClient}
 
-	packClient, err := pack.NewClient(pack.WithKeychain(DefaultKeychain), pack.WithDockerClient(dockerClient))
+	packClient, dockerClient, err := st.engine.packClient(&st.daemonImages, cfg.DaemonOnlyPrefixes)
 	if err != nil {
-		return "", fmt.Errorf("cannot create pack client: %w", err)
+		return "", err
 	}
 
 	createBuilderOpts := pack.CreateBuilderOptions{
 		RelativeBaseDir: filepath.Dir(builderTomlPath),
-		Targets: []dist.Target{
-			{
-				OS:   "linux",
-				Arch: arch,
-			},
-			{OS: "linux"},
-		},
-		BuilderName: newBuilderImageTagged,
-		Config:      builderConfig,
-		Publish:     false,
-		PullPolicy:  bpimage.PullAlways,
-		Labels: map[string]string{
-			"org.opencontainers.image.description": "Paketo Jammy builder enriched with Rust and Quarkus buildpack.",
-			"org.opencontainers.image.source":      "https://github.com/knative/func",
-			"org.opencontainers.image.vendor":      "https://github.com/knative/func",
-			"org.opencontainers.image.url":         "https://github.com/knative/func/pkgs/container/builder-jammy-" + variant,
-			"org.opencontainers.image.version":     version,
-		},
+		Targets:         st.engine.targets(arch),
+		BuilderName:     newBuilderImageTagged,
+		Config:          builderConfig,
+		// the daemonless engine publishes the builder to the local registry
+		Publish:    st.engine.daemonless(),
+		PullPolicy: bpimage.PullAlways,
+		Labels:     st.labels(distro, variant, version, arch, baseImage).expand(cfg.Labels),
 	}
 	fmt.Printf("## builderImage: '%v'\n", newBuilderImageTagged)
 	err = packClient.CreateBuilder(ctx, createBuilderOpts)
 	if err != nil {
 		return "", fmt.Errorf("cannont create builder: %w", err)
 	}
+	if st.engine.daemonless() {
+		desc, err := remote.Head(ref, registryOptions(ctx)...)
+		if err != nil {
+			return "", fmt.Errorf("cannot get digest of the builder: %w", err)
+		}
+		return newBuilderImage + "@" + desc.Digest.String(), nil
+	}
 
 	pushImage := func(img string) (string, error) {
 		regAuth, err := dockerDaemonAuthStr(img)
@@ -202,7 +438,12 @@ func buildBuilderImage(ctx context.Context, variant, version, arch, builderTomlP
 		}
 
 		if digest == "" {
-			return "", fmt.Errorf("digest not found")
+			// not every engine reports the digest in the push stream
+			desc, err := remote.Head(ref, registryOptions(ctx)...)
+			if err != nil {
+				return "", fmt.Errorf("digest not found: %w", err)
+			}
+			digest = desc.Digest.String()
 		}
 		return digest, nil
 	}
@@ -216,24 +457,112 @@ func buildBuilderImage(ctx context.Context, variant, version, arch, builderTomlP
 	return newBuilderImage + "@" + d, nil
 }
 
+// variantState is shared by builds of all arches of a variant.
+type variantState struct {
+	// client of upstream releases buildpacks are built from
+	ghClient *github.Client
+	// resolved versions of injected buildpacks (ID -> version)
+	versions map[string]string
+	// nil unless image references are pinned to digests
+	pinner *digestPinner
+	// nil unless images are relocated to our registry
+	relocator *relocator
+	// mirrors of stack images used when images are not relocated
+	mirrors *imageMapper
+	// nil for the default builder
+	profile *buildProfile
+	// buildpacks with dependencies built for the profile ("<arch> <uri>" -> image)
+	offline map[string]string
+	// outputs of finished stages
+	checkpoint     *variantCheckpoint
+	saveCheckpoint func() error
+	// engine builders and buildpacks are built with
+	engine *containerEngine
+	// buildpacks built into the daemon, pack must not pull them
+	daemonImages daemonImages
+	// creation time and commit of this repository in labels
+	created  time.Time
+	revision string
+}
+
+// returns values of labels of the builder of the arch, or of the index if arch is ""
+func (st *variantState) labels(distro, variant, version, arch, baseImage string) labelVars {
+	return labelVars{
+		distro:    distro,
+		variant:   variant,
+		profile:   st.profile,
+		version:   version,
+		arch:      arch,
+		sha:       st.revision,
+		created:   st.created,
+		baseImage: baseImage,
+	}
+}
+
+// returns the reference the stack image is mirrored to
+func (st *variantState) stackMirror(ref string) (string, error) {
+	if st.relocator != nil {
+		return st.relocator.destination(ref)
+	}
+	return st.mirrors.mapRef(ref)
+}
+
+// returns build and run images the builders of the variant are built with
+func (st *variantState) stackImages(upstream builder.Config) (string, string, error) {
+	var imgs [2]string
+	for i, ref := range []string{upstream.Stack.BuildImage, upstream.Stack.RunImage} {
+		img, err := st.stackMirror(ref)
+		if err != nil {
+			return "", "", fmt.Errorf("cannot mirror stack image: %w", err)
+		}
+		if st.pinner != nil {
+			if pinned, ok := st.pinner.pinned[img]; ok {
+				img = pinned
+			}
+		}
+		imgs[i] = img
+	}
+	return imgs[0], imgs[1], nil
+}
+
 // Builds builder for each arch and creates manifest list
-func buildBuilderImageMultiArch(ctx context.Context, variant string) error {
+// and publishes it. If profile is not nil the builder of the profile is built instead of the default one.
+// Stages finished by the run the checkpoint was saved by are skipped.
+func buildBuilderImageMultiArch(ctx context.Context, cfg *config, cp *checkpoint, engine *containerEngine, distro, variant string, profile *buildProfile, res *variantResult) error {
 	fmt.Println("#### buildMultiArch")
+	started := time.Now()
 	ghClient := newGHClient(ctx)
-	listOpts := &github.ListOptions{Page: 0, PerPage: 1}
-	releases, ghResp, err := ghClient.Repositories.ListReleases(ctx, "paketo-buildpacks", "builder-jammy-"+variant, listOpts)
-	if err != nil {
-		return fmt.Errorf("cannot get upstream builder release: %w", err)
-	}
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(ghResp.Body)
+	vc := cp.variant(variantKey(distro, variant, profile))
+	if vc.Published != "" {
+		fmt.Printf("## already published: '%v'\n", vc.Published)
+		vc.restoreResult(res)
+		return releaseStage(ctx, cfg, cp, vc, ghClient)
+	}
+
+	var release *github.RepositoryRelease
+	if vc.Release != "" {
+		// resumed runs build the release the checkpointed run started with
+		release = &github.RepositoryRelease{
+			Name:       github.Ptr(vc.Release),
+			TarballURL: github.Ptr(vc.TarballURL),
+			HTMLURL:    github.Ptr(vc.HTMLURL),
+		}
+	} else {
+		listOpts := &github.ListOptions{Page: 0, PerPage: 1}
+		owner, repo := upstreamRepo(cfg, distro, variant)
+		releases, ghResp, err := ghClient.Repositories.ListReleases(ctx, owner, repo, listOpts)
+		if err != nil {
+			return fmt.Errorf("cannot get upstream builder release: %w", err)
+		}
+		defer func(Body io.ReadCloser) {
+			_ = Body.Close()
+		}(ghResp.Body)
 
-	if len(releases) <= 0 {
-		return fmt.Errorf("cannot get latest release")
+		if len(releases) <= 0 {
+			return fmt.Errorf("cannot get latest release")
+		}
+		release = releases[0]
 	}
-
-	release := releases[0]
 	fmt.Printf("## releaseURL: '%v'\n", release.TarballURL)
 
 	if release.Name == nil {
@@ -242,6 +571,7 @@ func buildBuilderImageMultiArch(ctx context.Context, variant string) error {
 	if release.TarballURL == nil {
 		return fmt.Errorf("the tarball url of the release is not defined")
 	}
+	res.Release = release.GetName()
 
 	buildDir, err := os.MkdirTemp("", "")
 	fmt.Printf("## builderDir: '%v'\n", buildDir)
@@ -258,52 +588,148 @@ func buildBuilderImageMultiArch(ctx context.Context, variant string) error {
 		return fmt.Errorf("cannot download builder toml: %w", err)
 	}
 
-	remoteOpts := []remote.Option{
-		remote.WithAuthFromKeychain(DefaultKeychain),
-		remote.WithContext(ctx),
-	}
+	remoteOpts := registryOptions(ctx)
 
-	idxRef, err := name.ParseReference("ghcr.io/gauron99/builder-jammy-" + variant + ":" + release.GetName())
+	repos, err := builderRepos(cfg, distro, variant)
 	if err != nil {
-		return fmt.Errorf("cannot parse image index ref: %w", err)
+		return err
+	}
+	tag, latestTag := release.GetName()+profile.tagSuffix(), "latest"+profile.tagSuffix()
+	idxRef, latestRef := repos[0].Tag(tag), repos[0].Tag(latestTag)
+	var releaseTags []name.Tag
+	for _, repo := range repos {
+		releaseTags = append(releaseTags, repo.Tag(tag))
+	}
+
+	var signingKey *ecdsa.PrivateKey
+	if cfg.SigningKey != "" {
+		signingKey, err = loadSigningKey(cfg.SigningKey)
+		if err != nil {
+			return fmt.Errorf("cannot load signing key: %w", err)
+		}
 	}
 
-	_, err = remote.Index(idxRef, remoteOpts...)
+	existing, err := remote.Index(idxRef, remoteOpts...)
 	if err != nil {
 		if !isNotFound(err) {
 			return fmt.Errorf("cannot get image index: %w", err)
 		}
 	} else {
-		_, _ = fmt.Printf("index already present for tag: %s\n", release.GetName())
+		_, _ = fmt.Printf("index already present for tag: %s\n", idxRef.Identifier())
+		digest, err := existing.Digest()
+		if err != nil {
+			return fmt.Errorf("cannot get digest of the index: %w", err)
+		}
+		err = backfillIndex(cfg, existing, repos[1:], tag, signingKey, remoteOpts...)
+		if err != nil {
+			return err
+		}
+		err = checkDigestParity(releaseTags, digest, remoteOpts...)
+		if err != nil {
+			return err
+		}
+		res.Index = idxRef.String() + "@" + digest.String()
+		res.Published = publishedRefs(releaseTags, digest)
 		return nil
 	}
 
-	// just does copy now, both stacks are multi-arch (base,tiny)
-	err = buildStack(ctx, builderTomlPath)
+	mediaType, err := indexMediaType(cfg.IndexMediaType)
 	if err != nil {
-		return fmt.Errorf("cannot build stack: %w", err)
+		return err
+	}
+	for _, repo := range repos {
+		err = checkIndexMediaType(repo, mediaType, remoteOpts...)
+		if err != nil {
+			return err
+		}
 	}
 
-	idx := mutate.IndexMediaType(empty.Index, types.DockerManifestList)
-	idx = mutate.Annotations(idx, map[string]string{
-		"org.opencontainers.image.description": "Paketo Jammy builder enriched with Rust and Quarkus buildpack.",
-		"org.opencontainers.image.source":      "https://github.com/knative/func",
-		"org.opencontainers.image.vendor":      "https://github.com/knative/func",
-		"org.opencontainers.image.url":         "https://github.com/knative/func/pkgs/container/builder-jammy-" + variant,
-		"org.opencontainers.image.version":     *release.Name,
-	}).(v1.ImageIndex)
-	for _, arch := range []string{"arm64", "amd64"} {
-		if arch == "arm64" && variant == "full" {
-			_, _ = fmt.Fprintf(os.Stderr, "skipping arm64 build for variant: %q\n", variant)
-			continue
+	upstreamConfig, _, err := builder.ReadConfig(builderTomlPath)
+	if err != nil {
+		return fmt.Errorf("cannot parse builder.toml: %w", err)
+	}
+	resolver := &versionResolver{
+		ghClient:   ghClient,
+		remoteOpts: remoteOpts,
+		upstream:   &upstreamConfig,
+	}
+
+	// versions are resolved once, so that builders of all arches contain the same buildpacks
+	st := variantState{
+		ghClient:       ghClient,
+		engine:         engine,
+		profile:        profile,
+		offline:        make(map[string]string),
+		checkpoint:     vc,
+		saveCheckpoint: cp.save,
+	}
+	if vc.Versions != nil {
+		st.versions = vc.Versions
+	} else {
+		st.versions, err = resolveVersions(ctx, cfg, resolver)
+		if err != nil {
+			return fmt.Errorf("cannot resolve buildpack versions: %w", err)
+		}
+		vc.Release, vc.TarballURL, vc.HTMLURL = release.GetName(), release.GetTarballURL(), release.GetHTMLURL()
+		vc.Versions = st.versions
+		vc.Created = started.UTC().Truncate(time.Second)
+		err = cp.save()
+		if err != nil {
+			return err
 		}
+	}
+	res.Buildpacks = st.versions
+	st.created, st.revision = vc.Created, gitRevision(ctx)
+	if st.created.IsZero() {
+		st.created = started.UTC().Truncate(time.Second)
+	}
 
-		var imgName string
+	if cfg.PinDigests {
+		st.pinner = newDigestPinner(remoteOpts)
+		st.pinner.pinned = vc.Pinned
+		res.Digests = st.pinner.pinned
+	}
+	if cfg.Relocate != "" {
+		st.relocator = newRelocator(cfg.Relocate)
+		st.relocator.relocated = vc.Relocated
+		res.Relocated = st.relocator.relocated
+	}
+	st.mirrors, err = newImageMapper(cfg.StackMirrors)
+	if err != nil {
+		return fmt.Errorf("invalid stack mirrors: %w", err)
+	}
 
-		imgName, err = buildBuilderImage(ctx, variant, release.GetName(), arch, builderTomlPath)
+	if !vc.StackMirrored {
+		// just does copy now, both stacks are multi-arch (base,tiny)
+		err = buildStack(ctx, &st, builderTomlPath)
+		if err != nil {
+			return fmt.Errorf("cannot build stack: %w", err)
+		}
+		vc.StackMirrored = true
+		err = cp.save()
 		if err != nil {
 			return err
 		}
+	}
+
+	idx := mutate.IndexMediaType(empty.Index, mediaType)
+	annotations := st.labels(distro, variant, release.GetName(), "", upstreamConfig.Stack.BuildImage).expand(cfg.Annotations)
+	idx = mutate.Annotations(idx, annotations).(v1.ImageIndex)
+	archLabels := make(map[string]builderLabels)
+	for _, arch := range builderArches(variant) {
+		imgName := st.checkpointedBuilder(arch, remoteOpts...)
+		if imgName == "" {
+			imgName, err = buildBuilderImage(ctx, cfg, &st, distro, variant, release.GetName(), arch, builderTomlPath)
+			if err != nil {
+				return err
+			}
+			vc.Builders[arch] = imgName
+			err = cp.save()
+			if err != nil {
+				return err
+			}
+		}
+		res.arch(arch).Image = imgName
 
 		imgRef, err := name.ParseReference(imgName)
 		if err != nil {
@@ -319,11 +745,20 @@ func buildBuilderImageMultiArch(ctx context.Context, variant string) error {
 			return fmt.Errorf("cannot get config file for the image: %w", err)
 		}
 
+		archLabels[arch], err = readBuilderLabels(img)
+		if err != nil {
+			return fmt.Errorf("cannot read labels of %s builder: %w", arch, err)
+		}
+
 		newDesc, err := partial.Descriptor(img)
 		if err != nil {
 			return fmt.Errorf("cannot get partial descriptor for the image: %w", err)
 		}
 		newDesc.Platform = cf.Platform()
+		// descriptors in Docker manifest lists do not have annotations
+		if mediaType == types.OCIImageIndex {
+			newDesc.Annotations = st.labels(distro, variant, release.GetName(), arch, upstreamConfig.Stack.BuildImage).expand(cfg.Annotations)
+		}
 
 		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
 			Add:        img,
@@ -331,21 +766,120 @@ func buildBuilderImageMultiArch(ctx context.Context, variant string) error {
 		})
 	}
 
-	err = remote.WriteIndex(idxRef, idx, remoteOpts...)
+	err = checkManifestsAgree(archLabels)
 	if err != nil {
-		return fmt.Errorf("cannot write image index: %w", err)
+		return fmt.Errorf("refusing to write image index: %w", err)
+	}
+	// assembling the index is cheap, the digest is recorded to see whether the resumed run assembled the same one
+	digest, err := idx.Digest()
+	if err != nil {
+		return fmt.Errorf("cannot get digest of the index: %w", err)
+	}
+	if vc.Index != "" && vc.Index != digest.String() {
+		_, _ = fmt.Fprintf(os.Stderr, "index digest changed since the checkpoint: %s -> %s\n", vc.Index, digest)
+	}
+	vc.Index = digest.String()
+	err = cp.save()
+	if err != nil {
+		return err
+	}
+
+	// summary of the builder being replaced, for the diff in release notes
+	var previous *builderSummary
+	if cfg.ReleaseRepo != "" {
+		s, err := summarizeBuilder(latestRef, remoteOpts...)
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("cannot get previous builder: %w", err)
+		}
+		if err == nil {
+			previous = &s
+		}
 	}
 
-	idxRef, err = name.ParseReference("ghcr.io/gauron99/builder-jammy-" + variant + ":latest")
+	var in builderInputs
+	if cfg.Attestations {
+		in = builderInputs{
+			distro:     distro,
+			variant:    variant,
+			profile:    profile,
+			release:    release.GetName(),
+			tarballURL: release.GetTarballURL(),
+			injected:   st.versions,
+			labels:     archLabels[slices.Sorted(maps.Keys(archLabels))[0]],
+		}
+		in.buildImage, in.runImage, err = st.stackImages(upstreamConfig)
+		if err != nil {
+			return err
+		}
+	}
+	// the same index is written to all destinations, the primary one first
+	for _, repo := range repos {
+		err = publishToRepo(cfg, repo, idx, []name.Tag{repo.Tag(tag), repo.Tag(latestTag)}, signingKey, in, started, remoteOpts...)
+		if err != nil {
+			return err
+		}
+	}
+	err = checkDigestParity(releaseTags, digest, remoteOpts...)
 	if err != nil {
-		return fmt.Errorf("cannot parse image index ref: %w", err)
+		return err
 	}
+	res.Index = idxRef.String() + "@" + digest.String()
+	res.Published = publishedRefs(releaseTags, digest)
 
-	err = remote.WriteIndex(idxRef, idx, remoteOpts...)
+	if cfg.ReleaseRepo != "" {
+		current, err := summarizeBuilder(idxRef, remoteOpts...)
+		if err != nil {
+			return fmt.Errorf("cannot get published builder: %w", err)
+		}
+		notes, err := newReleaseNotes(idxRef, idx, release, st.versions, previous, current)
+		if err != nil {
+			return fmt.Errorf("cannot create release notes: %w", err)
+		}
+		vc.Notes = &notes
+	}
+	vc.Published, vc.Destinations = res.Index, res.Published
+	err = cp.save()
 	if err != nil {
-		return fmt.Errorf("cannot write image index: %w", err)
+		return err
 	}
 
+	return releaseStage(ctx, cfg, cp, vc, ghClient)
+}
+
+// creates GitHub release with the notes saved by the publish stage, unless it was created already
+func releaseStage(ctx context.Context, cfg *config, cp *checkpoint, vc *variantCheckpoint, ghClient *github.Client) error {
+	if cfg.ReleaseRepo == "" || vc.Notes == nil || vc.Released {
+		return nil
+	}
+	err := publishRelease(ctx, ghClient, cfg.ReleaseRepo, *vc.Notes)
+	if err != nil {
+		return err
+	}
+	vc.Released = true
+	return cp.save()
+}
+
+// Publishes the index under the tags of the repository. Signatures and attestations refer to the digest,
+// they are pushed before the index is visible under the tags. Attestations are not attached if in is empty.
+func publishToRepo(cfg *config, repo name.Repository, idx v1.ImageIndex, tags []name.Tag, signingKey *ecdsa.PrivateKey, in builderInputs, started time.Time, remoteOpts ...remote.Option) error {
+	err := publishIndex(cfg, idx, tags, func() error {
+		if signingKey != nil {
+			err := signIndex(signingKey, repo, idx, remoteOpts...)
+			if err != nil {
+				return fmt.Errorf("cannot sign image index: %w", err)
+			}
+		}
+		if in.release != "" {
+			err := attachAttestations(in, repo, idx, started, remoteOpts...)
+			if err != nil {
+				return fmt.Errorf("cannot attach attestations: %w", err)
+			}
+		}
+		return nil
+	}, remoteOpts...)
+	if err != nil {
+		return fmt.Errorf("cannot publish image index to %s: %w", repo, err)
+	}
 	return nil
 }
 
@@ -358,15 +892,21 @@ func isNotFound(err error) bool {
 }
 
 type buildpack struct {
-	repo      string
-	version   string
-	image     string
-	patchFunc func(packageDesc *buildpackage.Config, bpDesc *dist.BuildpackDescriptor)
+	repo    string
+	version string
+	image   string
+	// appended to the version in the image tag
+	tagSuffix string
+	// package dependencies of the buildpack (e.g. JDKs) into the image, optionally filtered
+	includeDependencies     bool
+	dependencyFilters       []string
+	strictDependencyFilters bool
+	patchFunc               func(ctx context.Context, packageDesc *buildpackage.Config, bpDesc *dist.BuildpackDescriptor) error
 }
 
-func buildBuildpackImage(ctx context.Context, bp buildpack, arch string) error {
+// builds image of the buildpack from its source release and returns the tagged image name
+func buildBuildpackImage(ctx context.Context, ghClient *github.Client, engine *containerEngine, bp buildpack, arch string) (string, error) {
 	fmt.Println("#### buildBuildpackImage")
-	ghClient := newGHClient(ctx)
 
 	var (
 		release *github.RepositoryRelease
@@ -380,55 +920,62 @@ func buildBuildpackImage(ctx context.Context, bp buildpack, arch string) error {
 		release, ghResp, err = ghClient.Repositories.GetReleaseByTag(ctx, "paketo-buildpacks", bp.repo, "v"+bp.version)
 	}
 	if err != nil {
-		return fmt.Errorf("cannot get upstream builder release: %w", err)
+		return "", fmt.Errorf("cannot get upstream builder release: %w", err)
 	}
 	defer func(Body io.ReadCloser) {
 		_ = Body.Close()
 	}(ghResp.Body)
 
 	if release.TarballURL == nil {
-		return fmt.Errorf("tarball url is nil")
+		return "", fmt.Errorf("tarball url is nil")
 	}
 	if release.TagName == nil {
-		return fmt.Errorf("tag name is nil")
+		return "", fmt.Errorf("tag name is nil")
 	}
 
 	version := strings.TrimPrefix(*release.TagName, "v")
 
-	imageNameTagged := bp.image + ":" + version
+	imageNameTagged := engine.buildpackImage(bp.image + ":" + version + bp.tagSuffix)
 	srcDir, err := os.MkdirTemp("", "src-*")
 	if err != nil {
-		return fmt.Errorf("cannot create temp dir: %w", err)
+		return "", fmt.Errorf("cannot create temp dir: %w", err)
 	}
+	defer func(path string) {
+		_ = os.RemoveAll(path)
+	}(srcDir)
 
-	err = downloadTarball(*release.TarballURL, srcDir)
+	err = downloadTarball(ctx, *release.TarballURL, srcDir)
 	if err != nil {
-		return fmt.Errorf("cannot download source code: %w", err)
+		return "", fmt.Errorf("cannot download source code: %w", err)
 	}
 
 	packageDir := filepath.Join(srcDir, "out")
 	p := carton.Package{
 		CacheLocation:           "",
-		DependencyFilters:       nil,
-		StrictDependencyFilters: false,
-		IncludeDependencies:     false,
+		DependencyFilters:       bp.dependencyFilters,
+		StrictDependencyFilters: bp.strictDependencyFilters,
+		IncludeDependencies:     bp.includeDependencies,
 		Destination:             packageDir,
 		Source:                  srcDir,
 		Version:                 version,
 	}
+	if bp.includeDependencies {
+		// only the binaries of the target arch are packaged
+		p.TargetArch = arch
+	}
 	eh := exitHandler{}
 	p.Create(carton.WithExitHandler(&eh))
 	if eh.err != nil {
-		return fmt.Errorf("cannot create package: %w", eh.err)
+		return "", fmt.Errorf("cannot create package: %w", eh.err)
 	}
 	if eh.fail {
-		return fmt.Errorf("cannot create package")
+		return "", fmt.Errorf("cannot create package")
 	}
 
 	// set URI and OS in package.toml
 	f, err := os.OpenFile(filepath.Join(srcDir, "package.toml"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
-		return fmt.Errorf("cannot open package.toml: %w", err)
+		return "", fmt.Errorf("cannot open package.toml: %w", err)
 	}
 	defer func(f *os.File) {
 		_ = f.Close()
@@ -436,13 +983,13 @@ func buildBuildpackImage(ctx context.Context, bp buildpack, arch string) error {
 	_, err = fmt.Fprintf(f, "[buildpack]\nuri = \"%s\"\n\n[platform]\nos = \"%s\"\n", packageDir, "linux")
 	_ = f.Close()
 	if err != nil {
-		return fmt.Errorf("cannot apped to package.toml: %w", err)
+		return "", fmt.Errorf("cannot apped to package.toml: %w", err)
 	}
 
 	cfgReader := buildpackage.NewConfigReader()
 	cfg, err := cfgReader.Read(filepath.Join(srcDir, "package.toml"))
 	if err != nil {
-		return fmt.Errorf("cannot read buildpack config: %w", err)
+		return "", fmt.Errorf("cannot read buildpack config: %w", err)
 	}
 
 	if bp.patchFunc != nil {
@@ -451,20 +998,23 @@ func buildBuildpackImage(ctx context.Context, bp buildpack, arch string) error {
 		bpDescPath := filepath.Join(packageDir, "buildpack.toml")
 		bs, err = os.ReadFile(bpDescPath)
 		if err != nil {
-			return fmt.Errorf("cannot read buildpack.toml: %w", err)
+			return "", fmt.Errorf("cannot read buildpack.toml: %w", err)
 		}
 		err = toml.Unmarshal(bs, &bpDesc)
 		if err != nil {
-			return fmt.Errorf("cannot unmarshall buildpack descriptor: %w", err)
+			return "", fmt.Errorf("cannot unmarshall buildpack descriptor: %w", err)
+		}
+		err = bp.patchFunc(ctx, &cfg, &bpDesc)
+		if err != nil {
+			return "", fmt.Errorf("cannot patch %q buildpack: %w", bpDesc.WithInfo.ID, err)
 		}
-		bp.patchFunc(&cfg, &bpDesc)
 		bs, err = toml.Marshal(&bpDesc)
 		if err != nil {
-			return fmt.Errorf("cannot marshal buildpack descriptor: %w", err)
+			return "", fmt.Errorf("cannot marshal buildpack descriptor: %w", err)
 		}
 		err = os.WriteFile(bpDescPath, bs, 0644)
 		if err != nil {
-			return fmt.Errorf("cannot write buildpack.toml: %w", err)
+			return "", fmt.Errorf("cannot write buildpack.toml: %w", err)
 		}
 	}
 
@@ -473,30 +1023,23 @@ func buildBuildpackImage(ctx context.Context, bp buildpack, arch string) error {
 		Name:            imageNameTagged,
 		Format:          pack.FormatImage,
 		Config:          cfg,
-		Publish:         false,
+		Publish:         engine.daemonless(),
 		PullPolicy:      bpimage.PullAlways,
 		Registry:        "",
 		Flatten:         false,
 		FlattenExclude:  nil,
-		Targets: []dist.Target{
-			{
-				OS:   "linux",
-				Arch: arch,
-			},
-			{OS: "linux"},
-		},
+		Targets:         engine.targets(arch),
 	}
-	packClient, err := pack.NewClient(pack.WithKeychain(DefaultKeychain))
+	packClient, _, err := engine.packClient(nil, nil)
 	if err != nil {
-		return fmt.Errorf("cannot create pack client: %w", err)
+		return "", err
 	}
-	fmt.Printf("## image, '%v'; targets: '%v'\n", pbo.Name, pbo.Targets)
 	err = packClient.PackageBuildpack(ctx, pbo)
 	if err != nil {
-		return fmt.Errorf("cannot package buildpack: %w", err)
+		return "", fmt.Errorf("cannot package buildpack: %w", err)
 	}
 
-	return nil
+	return imageNameTagged, nil
 }
 
 type exitHandler struct {
@@ -521,7 +1064,7 @@ func downloadBuilderToml(ctx context.Context, tarballUrl, builderTomlPath string
 		return fmt.Errorf("cannot create request for release tarball: %w", err)
 	}
 	//nolint:bodyclose
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := newDownloadClient().Do(req)
 	if err != nil {
 		return fmt.Errorf("cannot get release tarball: %w", err)
 	}
@@ -563,105 +1106,145 @@ func downloadBuilderToml(ctx context.Context, tarballUrl, builderTomlPath string
 	return nil
 }
 
-// Adds custom Rust buildpack to the builder.
-func addRustBuildpacks(config *builder.Config) {
-	config.Description += "\nAddendum: this builder contains community multi-arch Rust buildpack."
-	additionalBuildpacks := []builder.ModuleConfig{
-		{
+// Adds custom buildpacks (e.g. Rust) to the builder, each in its own order group before the upstream groups.
+func addBuildpacks(config *builder.Config, buildpacks []injectedBuildpack, versions map[string]string) {
+	var (
+		additionalBuildpacks []builder.ModuleConfig
+		additionalGroups     []dist.OrderEntry
+	)
+	for _, bp := range buildpacks {
+		if bp.Description != "" {
+			config.Description += "\n" + bp.Description
+		}
+		version := versions[bp.ID]
+		additionalBuildpacks = append(additionalBuildpacks, builder.ModuleConfig{
 			ModuleInfo: dist.ModuleInfo{
-				ID:      "paketo-community/rust",
-				Version: "0.65.0",
+				ID:      bp.ID,
+				Version: version,
 			},
 			ImageOrURI: dist.ImageOrURI{
-				BuildpackURI: dist.BuildpackURI{URI: "docker://docker.io/paketocommunity/rust:0.65.0"},
+				BuildpackURI: dist.BuildpackURI{URI: expandVersion(bp.URI, version)},
 			},
-		},
-	}
-
-	additionalGroups := []dist.OrderEntry{
-		{
+		})
+		additionalGroups = append(additionalGroups, dist.OrderEntry{
 			Group: []dist.ModuleRef{
 				{
 					ModuleInfo: dist.ModuleInfo{
-						ID: "paketo-community/rust",
+						ID: bp.ID,
 					},
 				},
 			},
-		},
+		})
 	}
 
 	config.Buildpacks = append(additionalBuildpacks, config.Buildpacks...)
 	config.Order = append(additionalGroups, config.Order...)
 }
 
-// updated java and java-native-image buildpack to include quarkus buildpack
-func updateJavaBuildpacks(ctx context.Context, builderConfig *builder.Config, arch string) error {
-	var err error
-	fmt.Println("#### updateJavaBuildpacks")
-	for _, entry := range builderConfig.Order {
-		bp := strings.TrimPrefix(entry.Group[0].ID, "paketo-buildpacks/")
-		if bp == "java" || bp == "java-native-image" {
-			img := "ghcr.io/gauron99/buildpacks/" + bp
-			err = buildBuildpackImage(ctx, buildpack{
-				repo:      bp,
-				version:   entry.Group[0].Version,
-				image:     img,
-				patchFunc: addQuarkusBuildpack,
-			}, arch)
-			// TODO we might want to push these images to registry
-			// but it's not absolutely necessary since they are included in builder
+// resolves versions of injected buildpacks and of modules inserted by patches (ID -> version)
+func resolveVersions(ctx context.Context, cfg *config, resolver *versionResolver) (map[string]string, error) {
+	versions := make(map[string]string)
+	resolve := func(id string, spec versionSpec) error {
+		if _, ok := versions[id]; ok {
+			return nil
+		}
+		v, err := resolver.resolve(ctx, id, spec)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("## resolved version: %s@%s (%s)\n", id, v, spec.Strategy)
+		versions[id] = v
+		return nil
+	}
+	for _, bp := range cfg.Buildpacks {
+		err := resolve(bp.ID, bp.Version)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range cfg.Patches {
+		for _, op := range p.Operations {
+			if op.Module.ID == "" {
+				continue
+			}
+			err := resolve(op.Module.ID, op.Module.Version)
 			if err != nil {
-				return fmt.Errorf("cannot build %q buildpack: %w", bp, err)
+				return nil, err
 			}
-			fmt.Printf("### changing buildpacks URI: %+v\n", builderConfig.Buildpacks)
-			fmt.Printf("### if it matches %v\n", "docker://docker.io/paketobuildpacks/"+bp+":")
-			for i := range builderConfig.Buildpacks {
-				if strings.HasPrefix(builderConfig.Buildpacks[i].URI, "docker://docker.io/paketobuildpacks/"+bp+":") {
-					fmt.Printf("### matches! current URI=%v\n", builderConfig.Buildpacks[i].URI)
-					builderConfig.Buildpacks[i].URI = "docker://ghcr.io/gauron99/buildpacks/" + bp + ":" + entry.Group[0].Version
-					fmt.Printf("### updated! URI=%v\n", builderConfig.Buildpacks[i].URI)
+		}
+	}
+	return versions, nil
+}
+
+// rebuilds composite buildpacks (e.g. java and java-native-image) with their order patched
+// according to cfg.Patches, e.g. to include quarkus buildpack,
+// and returns the images built, those exist only in the daemon. Dependencies are relocated and pinned if enabled.
+func patchCompositeBuildpacks(ctx context.Context, cfg *config, st *variantState, builderConfig *builder.Config, arch string) ([]string, error) {
+	var (
+		err    error
+		images []string
+	)
+	fmt.Println("#### patchCompositeBuildpacks")
+	for _, entry := range builderConfig.Order {
+		id := entry.Group[0].ID
+		i := slices.IndexFunc(cfg.Patches, func(p compositePatch) bool {
+			return p.appliesTo(id)
+		})
+		if i < 0 {
+			continue
+		}
+		patch := cfg.Patches[i]
+		bp := buildpackName(id)
+		var img string
+		img, err = st.buildBuildpack(ctx, buildpack{
+			repo:      bp,
+			version:   entry.Group[0].Version,
+			image:     "ghcr.io/gauron99/buildpacks/" + bp,
+			tagSuffix: st.profile.tagSuffix(),
+			patchFunc: func(ctx context.Context, packageDesc *buildpackage.Config, bpDesc *dist.BuildpackDescriptor) error {
+				err := applyOrderPatches(patch.Operations, st.versions, packageDesc, bpDesc)
+				if err != nil {
+					return err
+				}
+				offlineImages, err := st.offlinePackageConfig(ctx, packageDesc, arch)
+				if err != nil {
+					return err
+				}
+				images = append(images, offlineImages...)
+				if st.relocator != nil {
+					err = st.relocator.relocatePackageConfig(ctx, packageDesc, offlineImages)
+					if err != nil {
+						return err
+					}
+				}
+				if st.pinner != nil {
+					return st.pinner.pinPackageConfig(packageDesc, offlineImages)
 				}
+				return nil
+			},
+		}, arch)
+		// TODO we might want to push these images to registry
+		// but it's not absolutely necessary since they are included in builder
+		if err != nil {
+			return nil, fmt.Errorf("cannot build %q buildpack: %w", bp, err)
+		}
+		images = append(images, img)
+		for i := range builderConfig.Buildpacks {
+			if strings.HasPrefix(builderConfig.Buildpacks[i].URI, "docker://docker.io/paketobuildpacks/"+bp+":") {
+				builderConfig.Buildpacks[i].URI = dockerScheme + img
 			}
 		}
 	}
-	return nil
+	return images, nil
 }
 
-// patches "Java" or "Java Native Image" buildpacks to include Quarkus BP just before Maven BP
-func addQuarkusBuildpack(packageDesc *buildpackage.Config, bpDesc *dist.BuildpackDescriptor) {
-	ghClient := newGHClient(context.Background())
-
-	rr, resp, err := ghClient.Repositories.GetLatestRelease(context.TODO(), "paketo-buildpacks", "quarkus")
+func downloadTarball(ctx context.Context, tarballUrl, destDir string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tarballUrl, nil)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("cannot create request for tarball: %w", err)
 	}
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(resp.Body)
-
-	latestQuarkusVersion := strings.TrimPrefix(*rr.TagName, "v")
-
-	packageDesc.Dependencies = append(packageDesc.Dependencies, dist.ImageOrURI{
-		BuildpackURI: dist.BuildpackURI{
-			URI: "docker://index.docker.io/paketobuildpacks/quarkus:" + latestQuarkusVersion,
-		},
-	})
-	quarkusBP := dist.ModuleRef{
-		ModuleInfo: dist.ModuleInfo{
-			ID:      "paketo-buildpacks/quarkus",
-			Version: latestQuarkusVersion,
-		},
-		Optional: true,
-	}
-	idx := slices.IndexFunc(bpDesc.WithOrder[0].Group, func(ref dist.ModuleRef) bool {
-		return ref.ID == "paketo-buildpacks/maven"
-	})
-	bpDesc.WithOrder[0].Group = slices.Insert(bpDesc.WithOrder[0].Group, idx, quarkusBP)
-}
-
-func downloadTarball(tarballUrl, destDir string) error {
 	//nolint:bodyclose
-	resp, err := http.Get(tarballUrl)
+	resp, err := newDownloadClient().Do(req)
 	if err != nil {
 		return fmt.Errorf("cannot get tarball: %w", err)
 	}
@@ -734,13 +1317,18 @@ func downloadTarball(tarballUrl, destDir string) error {
 	return nil
 }
 
+// returns GitHub client retrying calls according to the GitHub retry policy
 func newGHClient(ctx context.Context) *github.Client {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{
+		Transport: newRetryTransport("github", retryPolicies.GitHub, http.DefaultTransport),
+	})
 	return github.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{
 		AccessToken: os.Getenv("GITHUB_TOKEN"),
 	})))
 }
 
-var DefaultKeychain = authn.NewMultiKeychain(ghAuth.Keychain, authn.DefaultKeychain)
+// DefaultKeychain resolves registry credentials of all clients, set from configuration by the commands
+var DefaultKeychain = newRegistryKeychain(nil)
 
 func dockerDaemonAuthStr(img string) (string, error) {
 	ref, err := name.ParseReference(img)
@@ -759,8 +1347,10 @@ func dockerDaemonAuthStr(img string) (string, error) {
 	}
 
 	authConfig := registry.AuthConfig{
-		Username: ac.Username,
-		Password: ac.Password,
+		Username:      ac.Username,
+		Password:      ac.Password,
+		IdentityToken: ac.IdentityToken,
+		RegistryToken: ac.RegistryToken,
 	}
 
 	bs, err := json.Marshal(&authConfig)
@@ -771,66 +1361,107 @@ func dockerDaemonAuthStr(img string) (string, error) {
 	return base64.StdEncoding.EncodeToString(bs), nil
 }
 
-// Hack implementation of docker client returns NotFound for images ghcr.io/knative/buildpacks/*
+// Hack implementation of docker client returns NotFound for images that exist only in the daemon,
+// so that pack uses them instead of pulling them. These are buildpacks built by this run and images
+// matching the configured prefixes.
 // For some reason moby/docker erroneously returns 500 HTTP code for these missing images.
 // Interestingly podman correctly returns 404 for same request.
 type hackDockerClient struct {
 	docker.APIClient
+	daemonImages *daemonImages
+	prefixes     []string
 }
 
 func (c hackDockerClient) ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error) {
-	if strings.HasPrefix(ref, "ghcr.io/knative/buildpacks/") {
+	if c.daemonImages.contains(ref) || slices.ContainsFunc(c.prefixes, func(prefix string) bool {
+		return strings.HasPrefix(ref, prefix)
+	}) {
 		return nil, fmt.Errorf("this image is supposed to exist only in daemon: %w", errdefs.ErrNotFound)
 	}
 	return c.APIClient.ImagePull(ctx, ref, options)
 }
 
-func fixupStacks(builderConfig *builder.Config) {
+// daemonImages are images built into the daemon by this run, they do not exist in any registry.
+type daemonImages struct {
+	// normalized references
+	refs map[string]bool
+}
+
+func (d *daemonImages) add(ref string) {
+	if d.refs == nil {
+		d.refs = make(map[string]bool)
+	}
+	d.refs[normalizeRef(ref)] = true
+}
+
+func (d *daemonImages) contains(ref string) bool {
+	return d != nil && d.refs[normalizeRef(ref)]
+}
+
+// returns fully qualified reference, e.g. "index.docker.io/library/busybox:latest" for "busybox"
+func normalizeRef(ref string) string {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return ref
+	}
+	return r.Name()
+}
+
+// points stack images to their mirrors
+func fixupStacks(builderConfig *builder.Config, mirror func(ref string) (string, error)) error {
 	fmt.Println("#### fixupStacks")
-	newBuilder := stackImageToMirror(builderConfig.Stack.BuildImage)
+	newBuilder, err := mirror(builderConfig.Stack.BuildImage)
+	if err != nil {
+		return fmt.Errorf("cannot mirror build image: %w", err)
+	}
 	fmt.Printf("## buildimage: '%v'\n", newBuilder)
 	builderConfig.Stack.BuildImage = newBuilder
 	builderConfig.Build.Image = newBuilder
 
-	newRun := stackImageToMirror(builderConfig.Stack.RunImage)
+	newRun, err := mirror(builderConfig.Stack.RunImage)
+	if err != nil {
+		return fmt.Errorf("cannot mirror run image: %w", err)
+	}
 	fmt.Printf("## runimage: '%v'\n", newRun)
 	builderConfig.Stack.RunImage = newRun
 	builderConfig.Run.Images = []builder.RunImageConfig{{
 		Image: newRun,
 	}}
+	return nil
 }
 
 func copyImage(ctx context.Context, srcRef, destRef string) error {
 	fmt.Printf("copyImage '%v' -> '%v'\n", srcRef, destRef)
 	_, _ = fmt.Fprintf(os.Stderr, "copying: %s => %s\n", srcRef, destRef)
+	// skopeo gets credentials of the keychain, so it does not need to be logged in
+	dir, err := os.MkdirTemp("", "auth-")
+	if err != nil {
+		return fmt.Errorf("cannot create temporary directory: %w", err)
+	}
+	defer func(path string) {
+		_ = os.RemoveAll(path)
+	}(dir)
+	authFile, err := writeAuthFile(ctx, dir, DefaultKeychain, srcRef, destRef)
+	if err != nil {
+		return err
+	}
 	cmd := exec.CommandContext(ctx, "skopeo", "copy",
+		"--authfile", authFile,
 		"--multi-arch=all",
 		"docker://"+srcRef,
 		"docker://"+destRef,
 	)
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
-	err := cmd.Run()
+	err = cmd.Run()
 	if err != nil {
 		return fmt.Errorf("error while running skopeo: %w", err)
 	}
 	return nil
 }
 
-func stackImageToMirror(ref string) string {
-	parts := strings.Split(ref, "/")
-	lastPart := parts[len(parts)-1]
-	switch {
-	case strings.HasPrefix(lastPart, "build-"):
-		return "localhost:5000/" + lastPart
-	case strings.HasPrefix(lastPart, "run-"):
-		return "ghcr.io/gauron99/" + lastPart
-	default:
-		panic("non reachable")
-	}
-}
-
-func buildStack(ctx context.Context, builderTomlPath string) error {
+// mirrors stack images, into our registry namespace if images are relocated
+func buildStack(ctx context.Context, st *variantState, builderTomlPath string) error {
 	fmt.Println("#### buildStack")
 	var err error
 
@@ -842,110 +1473,34 @@ func buildStack(ctx context.Context, builderTomlPath string) error {
 	buildImage := builderConfig.Stack.BuildImage
 	runImage := builderConfig.Stack.RunImage
 
-	err = copyImage(ctx, buildImage, stackImageToMirror(buildImage))
-	if err != nil {
-		return fmt.Errorf("cannot mirror build image: %w", err)
-	}
-
-	err = copyImage(ctx, runImage, stackImageToMirror(runImage))
-	if err != nil {
-		return fmt.Errorf("cannot mirror run image: %w", err)
-	}
-
-	return nil
-}
-
-func buildBaseStack(ctx context.Context, buildImage, runImage string) error {
-	fmt.Println("#### buildBaseStack")
-	cli := newGHClient(ctx)
-
-	parts := strings.Split(buildImage, ":")
-	stackVersion := parts[len(parts)-1]
-
-	rel, resp, err := cli.Repositories.GetReleaseByTag(ctx, "paketo-buildpacks", "jammy-base-stack", "v"+stackVersion)
-	if err != nil {
-		return fmt.Errorf("cannot get release: %w", err)
-	}
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(resp.Body)
-
-	src, err := os.MkdirTemp("", "src-dir")
-	if err != nil {
-		return fmt.Errorf("cannot create temp dir: %w", err)
-	}
-
-	err = downloadTarball(rel.GetTarballURL(), src)
-	if err != nil {
-		return fmt.Errorf("cannot download source tarball: %w", err)
-	}
-
-	err = patchStack(filepath.Join(src, "stack", "stack.toml"))
-	if err != nil {
-		return fmt.Errorf("cannot patch stack toml: %w", err)
-	}
-
-	script := fmt.Sprintf(`
-set -ex
-scripts/create.sh
-.bin/jam publish-stack --build-ref %q --run-ref %q --build-archive build/build.oci --run-archive build/run.oci
-`, stackImageToMirror(buildImage), runImage)
-
-	cmd := exec.CommandContext(ctx, "sh", "-c", script)
-	cmd.Dir = src
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	err = cmd.Run()
-	if err != nil {
-		return fmt.Errorf("cannot build stack: %w", err)
+	if st.relocator != nil {
+		_, err = st.relocator.relocate(ctx, buildImage)
+		if err != nil {
+			return fmt.Errorf("cannot relocate build image: %w", err)
+		}
+		_, err = st.relocator.relocate(ctx, runImage)
+		if err != nil {
+			return fmt.Errorf("cannot relocate run image: %w", err)
+		}
+		return nil
 	}
 
-	parts = strings.Split(runImage, "/")
-	lastPart := parts[len(parts)-1]
-	quayDest := "quay.io/gauron99/knative/" + lastPart
-	err = copyImage(ctx, runImage, quayDest)
+	buildMirror, err := st.mirrors.mapRef(buildImage)
 	if err != nil {
-		return fmt.Errorf("couldn not copy the run image to my quay :(: %v", err)
-	}
-	fmt.Printf("#### copied runImage to quay: '%v' -> '%v'\n", runImage, quayDest)
-	return nil
-}
-
-func patchStack(stackTomlPath string) error {
-	input, err := os.ReadFile(stackTomlPath)
-	if err != nil {
-		return fmt.Errorf("cannot open stack toml: %w", err)
+		return fmt.Errorf("cannot mirror build image: %w", err)
 	}
-
-	var data any
-	err = toml.Unmarshal(input, &data)
+	err = copyImage(ctx, buildImage, buildMirror)
 	if err != nil {
-		return fmt.Errorf("cannot decode data: %w", err)
-	}
-
-	m := data.(map[string]any)
-	m["platforms"] = []string{"linux/amd64", "linux/arm64"}
-
-	args := map[string]interface{}{
-		"args": map[string]interface{}{
-			"architecture": "arm64",
-			"sources": `    deb http://ports.ubuntu.com/ubuntu-ports/ jammy main universe multiverse
-    deb http://ports.ubuntu.com/ubuntu-ports/ jammy-updates main universe multiverse
-    deb http://ports.ubuntu.com/ubuntu-ports/ jammy-security main universe multiverse
-    `},
+		return fmt.Errorf("cannot mirror build image: %w", err)
 	}
 
-	m["build"].(map[string]any)["platforms"] = map[string]any{"linux/arm64": args}
-	m["run"].(map[string]any)["platforms"] = map[string]any{"linux/arm64": args}
-
-	output, err := toml.Marshal(data)
+	runMirror, err := st.mirrors.mapRef(runImage)
 	if err != nil {
-		return fmt.Errorf("cannot marshal config: %w", err)
+		return fmt.Errorf("cannot mirror run image: %w", err)
 	}
-	err = os.WriteFile(stackTomlPath, output, 0644)
+	err = copyImage(ctx, runImage, runMirror)
 	if err != nil {
-		return fmt.Errorf("cannot write patched stack toml: %w", err)
+		return fmt.Errorf("cannot mirror run image: %w", err)
 	}
 
 	return nil
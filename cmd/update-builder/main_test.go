@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildpacks/pack/buildpackage"
+	"github.com/buildpacks/pack/pkg/dist"
+	"github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/image"
+	docker "github.com/docker/docker/client"
+	"github.com/google/go-github/v68/github"
+)
+
+// pullRecorder is docker client recording pulled images
+type pullRecorder struct {
+	docker.APIClient
+	pulled []string
+}
+
+func (c *pullRecorder) ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error) {
+	c.pulled = append(c.pulled, ref)
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func TestHackDockerClientImagePull(t *testing.T) {
+	var local daemonImages
+	local.add("ghcr.io/gauron99/buildpacks/java:18.9.0-arm64")
+	rec := &pullRecorder{}
+	cli := hackDockerClient{
+		APIClient:    rec,
+		daemonImages: &local,
+		prefixes:     defaultConfig().DaemonOnlyPrefixes,
+	}
+
+	tests := []struct {
+		ref    string
+		pulled bool
+	}{
+		{ref: "ghcr.io/gauron99/buildpacks/java:18.9.0-arm64"},
+		{ref: "ghcr.io/knative/buildpacks/java:18.9.0"},
+		{ref: "ghcr.io/gauron99/buildpacks/java:18.9.0-amd64", pulled: true},
+		{ref: "docker.io/paketobuildpacks/quarkus:0.5.0", pulled: true},
+	}
+	for _, tt := range tests {
+		rec.pulled = nil
+		rc, err := cli.ImagePull(context.Background(), tt.ref, image.PullOptions{})
+		if tt.pulled {
+			if err != nil {
+				t.Errorf("%s: %v", tt.ref, err)
+				continue
+			}
+			_ = rc.Close()
+			if len(rec.pulled) != 1 {
+				t.Errorf("%s was not pulled", tt.ref)
+			}
+			continue
+		}
+		if !errdefs.IsNotFound(err) {
+			t.Errorf("%s: expected not found, got: %v", tt.ref, err)
+		}
+		if len(rec.pulled) != 0 {
+			t.Errorf("%s was pulled", tt.ref)
+		}
+	}
+
+	if !local.contains("ghcr.io/gauron99/buildpacks/java:18.9.0-arm64") || local.contains("busybox") {
+		t.Error("unexpected daemon images")
+	}
+	var empty *daemonImages
+	if empty.contains("busybox") {
+		t.Error("nil set contains image")
+	}
+}
+
+// returns gzipped tarball of files in a top level directory, as GitHub serves sources of releases
+func sourceTarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for n, content := range files {
+		err := tw.WriteHeader(&tar.Header{Name: "source/" + n, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBuildBuildpackImageHookError(t *testing.T) {
+	tarball := sourceTarball(t, map[string]string{
+		"buildpack.toml": `api = "0.7"
+
+[buildpack]
+id = "paketo-buildpacks/java"
+version = "{{.version}}"
+
+[[order]]
+[[order.group]]
+id = "paketo-buildpacks/maven"
+version = "6.15.0"
+
+[metadata]
+include-files = ["buildpack.toml"]
+`,
+		"package.toml": "",
+	})
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/repos/paketo-buildpacks/java/releases/tags/v18.9.0", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"tag_name":"v18.9.0","tarball_url":%q}`, srv.URL+"/tarball")
+	})
+	mux.HandleFunc("/tarball", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(tarball)
+	})
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	// sources are unpacked to temporary directory, which has to be removed when the hook fails
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	hookErr := errors.New("hook failed")
+	var called bool
+	bp := buildpack{
+		repo:    "java",
+		version: "18.9.0",
+		image:   "localhost:5000/java",
+		patchFunc: func(ctx context.Context, packageDesc *buildpackage.Config, bpDesc *dist.BuildpackDescriptor) error {
+			called = true
+			return hookErr
+		},
+	}
+	_, err := buildBuildpackImage(context.Background(), client, &containerEngine{name: engineDaemonless}, bp, "amd64")
+	if !called {
+		t.Fatalf("hook not called: %v", err)
+	}
+	if !errors.Is(err, hookErr) {
+		t.Errorf("expected hook error, got: %v", err)
+	}
+
+	left, err := filepath.Glob(filepath.Join(tmp, "src-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) > 0 {
+		t.Errorf("temporary directories left behind: %v", left)
+	}
+}
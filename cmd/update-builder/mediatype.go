@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Settings of the media type of published builder indexes.
+const (
+	// Docker manifest list, supported by all registries.
+	indexTypeDocker = "docker"
+	// OCI image index, descriptors of its manifests carry annotations.
+	indexTypeOCI = "oci"
+)
+
+// returns media type of builder indexes of the setting
+func indexMediaType(setting string) (types.MediaType, error) {
+	switch setting {
+	case indexTypeDocker, "":
+		return types.DockerManifestList, nil
+	case indexTypeOCI:
+		return types.OCIImageIndex, nil
+	default:
+		return "", fmt.Errorf("unsupported index media type %q, expected %q or %q", setting, indexTypeDocker, indexTypeOCI)
+	}
+}
+
+// tag the media type of indexes is probed with, the same for each run so that registries
+// that do not support deleting tags keep only one of it
+const mediaTypeProbeTag = "media-type-probe"
+
+// Checks that the registry of the repository accepts indexes of the media type by pushing an empty index
+// to a probe tag, so that an unsupported media type is found out before builders are built.
+// The probe tag is deleted afterwards. Docker manifest lists are accepted by all registries, they are not probed.
+func checkIndexMediaType(repo name.Repository, mediaType types.MediaType, remoteOpts ...remote.Option) error {
+	if mediaType == types.DockerManifestList {
+		return nil
+	}
+	fmt.Println("#### checkIndexMediaType")
+	probe := repo.Tag(mediaTypeProbeTag)
+	err := remote.WriteIndex(probe, mutate.IndexMediaType(empty.Index, mediaType), remoteOpts...)
+	if err != nil {
+		return fmt.Errorf("registry of %s does not accept %s: %w", repo, mediaType, err)
+	}
+	// registries that do not support deleting tags keep the probe tag until the next run overwrites it
+	err = remote.Delete(probe, remoteOpts...)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "cannot delete probe tag %s, it is kept: %v\n", probe, err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestCheckIndexMediaType(t *testing.T) {
+	reg := registry.New()
+	var probes []string
+	rejectOCI := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+			probes = append(probes, r.URL.Path)
+			if rejectOCI && r.Header.Get("Content-Type") == string(types.OCIImageIndex) {
+				http.Error(w, `{"errors":[{"code":"MANIFEST_INVALID","message":"unsupported media type"}]}`, http.StatusBadRequest)
+				return
+			}
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	repo, err := name.NewRepository(strings.TrimPrefix(srv.URL, "http://") + "/gauron99/builder-jammy-base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Docker manifest lists are not probed
+	err = checkIndexMediaType(repo, types.DockerManifestList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(probes) != 0 {
+		t.Errorf("probes = %v", probes)
+	}
+
+	err = checkIndexMediaType(repo, types.OCIImageIndex)
+	if err == nil {
+		t.Error("expected error for OCI index")
+	}
+
+	rejectOCI = false
+	for range 2 {
+		err = checkIndexMediaType(repo, types.OCIImageIndex)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !slices.Equal(probes, slices.Repeat([]string{"/v2/gauron99/builder-jammy-base/manifests/" + mediaTypeProbeTag}, 3)) {
+		t.Errorf("probes = %v", probes)
+	}
+	tags, err := remote.List(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 0 {
+		t.Errorf("probe tags were not deleted: %v", tags)
+	}
+}
+
+func TestInspectBuilderMediaType(t *testing.T) {
+	cfg := defaultConfig()
+	bl := testBuilderLabels(cfg)
+	idx := testBuilderIndex(t, map[string]builderLabels{"amd64": bl, "arm64": bl})
+	ref := pushTestIndex(t, testRegistry(t)+"/gauron99/builder-jammy-base:v0.4.0", mutate.IndexMediaType(idx, types.OCIImageIndex))
+
+	for setting, failed := range map[string]bool{indexTypeDocker: true, indexTypeOCI: false} {
+		cfg.IndexMediaType = setting
+		report, err := inspectBuilder(&cfg, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.failed() != failed {
+			var sb strings.Builder
+			report.print(&sb)
+			t.Errorf("%s: failed = %v, want %v\n%s", setting, report.failed(), failed, sb.String())
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/buildpacks/pack/pkg/dist"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// Labels pack puts on builder images.
+const (
+	builderMetadataLabel = "io.buildpacks.builder.metadata"
+	buildpackOrderLabel  = "io.buildpacks.buildpack.order"
+)
+
+// builderMetadata is the subset of the io.buildpacks.builder.metadata label we care about.
+type builderMetadata struct {
+	Description string            `json:"description"`
+	Buildpacks  []dist.ModuleInfo `json:"buildpacks"`
+	Stack       struct {
+		RunImage struct {
+			Image   string   `json:"image"`
+			Mirrors []string `json:"mirrors"`
+		} `json:"runImage"`
+	} `json:"stack"`
+	Lifecycle struct {
+		Version string `json:"version"`
+	} `json:"lifecycle"`
+}
+
+// builderLabels are the buildpack related labels of a single builder image.
+type builderLabels struct {
+	Metadata builderMetadata
+	Order    dist.Order
+	// Layers of all buildpacks including the ones nested in composite buildpacks.
+	Layers dist.ModuleLayers
+}
+
+func readBuilderLabels(img v1.Image) (builderLabels, error) {
+	var bl builderLabels
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return bl, fmt.Errorf("cannot get config file for the image: %w", err)
+	}
+	labels := cf.Config.Labels
+
+	for label, dest := range map[string]any{
+		builderMetadataLabel:      &bl.Metadata,
+		buildpackOrderLabel:       &bl.Order,
+		dist.BuildpackLayersLabel: &bl.Layers,
+	} {
+		val, ok := labels[label]
+		if !ok {
+			return bl, fmt.Errorf("label %q is missing", label)
+		}
+		err = json.Unmarshal([]byte(val), dest)
+		if err != nil {
+			return bl, fmt.Errorf("cannot parse label %q: %w", label, err)
+		}
+	}
+	return bl, nil
+}
+
+// returns sorted "<id>@<version>" of all buildpacks in the builder, nested ones included
+func (bl builderLabels) modules() []string {
+	var mods []string
+	for id, versions := range bl.Layers {
+		for version := range versions {
+			mods = append(mods, id+"@"+version)
+		}
+	}
+	slices.Sort(mods)
+	return mods
+}
+
+// checks that builders of all arches (arch -> labels) contain the same buildpacks in the same order
+func checkManifestsAgree(archLabels map[string]builderLabels) error {
+	var (
+		refArch string
+		ref     builderLabels
+	)
+	for _, arch := range slices.Sorted(maps.Keys(archLabels)) {
+		bl := archLabels[arch]
+		if refArch == "" {
+			refArch, ref = arch, bl
+			continue
+		}
+		if !slices.Equal(ref.modules(), bl.modules()) {
+			return fmt.Errorf("buildpacks of %s and %s builders differ: %v != %v", refArch, arch, ref.modules(), bl.modules())
+		}
+		if !reflect.DeepEqual(ref.Order, bl.Order) {
+			return fmt.Errorf("order of %s and %s builders differ", refArch, arch)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/buildpacks/pack/pkg/dist"
+)
+
+func TestCheckManifestsAgree(t *testing.T) {
+	labels := func(quarkusVersion string) builderLabels {
+		return builderLabels{
+			Order: dist.Order{{Group: []dist.ModuleRef{{ModuleInfo: dist.ModuleInfo{ID: "paketo-buildpacks/java"}}}}},
+			Layers: dist.ModuleLayers{
+				"paketo-buildpacks/java":    {"18.9.0": {}},
+				"paketo-buildpacks/quarkus": {quarkusVersion: {}},
+			},
+		}
+	}
+
+	err := checkManifestsAgree(map[string]builderLabels{"amd64": labels("1.2.3"), "arm64": labels("1.2.3")})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = checkManifestsAgree(map[string]builderLabels{"amd64": labels("1.2.3"), "arm64": labels("1.2.4")})
+	if err == nil {
+		t.Error("expected error for different quarkus versions")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// mirrorRule maps an image reference to the reference of its mirror.
+//
+// Either Prefix or Regex has to be set. Destination is a template expanded
+// by regexp.Expand: named and numbered groups of Regex can be referenced,
+// e.g. "${name}", and for Prefix rules "${rest}" is the part of the
+// reference after the prefix.
+type mirrorRule struct {
+	Prefix      string `json:"prefix,omitempty"`
+	Regex       string `json:"regex,omitempty"`
+	Destination string `json:"destination"`
+}
+
+// imageMapper maps image references by the first matching rule.
+type imageMapper struct {
+	rules []compiledMirrorRule
+}
+
+type compiledMirrorRule struct {
+	re          *regexp.Regexp
+	destination string
+}
+
+func newImageMapper(rules []mirrorRule) (*imageMapper, error) {
+	m := &imageMapper{}
+	for i, rule := range rules {
+		var expr string
+		switch {
+		case rule.Prefix != "" && rule.Regex == "":
+			expr = "^" + regexp.QuoteMeta(rule.Prefix) + "(?P<rest>.*)$"
+		case rule.Regex != "" && rule.Prefix == "":
+			expr = rule.Regex
+		default:
+			return nil, fmt.Errorf("mirror rule %d: exactly one of prefix or regex has to be set", i)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("mirror rule %d: %w", i, err)
+		}
+		if rule.Destination == "" {
+			return nil, fmt.Errorf("mirror rule %d: destination not set", i)
+		}
+		m.rules = append(m.rules, compiledMirrorRule{re: re, destination: rule.Destination})
+	}
+	return m, nil
+}
+
+func (m *imageMapper) mapRef(ref string) (string, error) {
+	for _, rule := range m.rules {
+		match := rule.re.FindStringSubmatchIndex(ref)
+		if match == nil {
+			continue
+		}
+		return string(rule.re.ExpandString(nil, rule.destination, ref, match)), nil
+	}
+	return "", fmt.Errorf("no mirror rule matches %q", ref)
+}
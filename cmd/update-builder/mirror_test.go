@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestImageMapper(t *testing.T) {
+	m, err := newImageMapper(append(defaultConfig().StackMirrors, mirrorRule{
+		Prefix:      "docker.io/paketobuildpacks/",
+		Destination: "harbor.example.com/paketo/${rest}",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"docker.io/paketobuildpacks/build-jammy-base:0.1.150":     "localhost:5000/build-jammy-base:0.1.150",
+		"index.docker.io/paketobuildpacks/run-jammy-base:latest":  "ghcr.io/gauron99/run-jammy-base:latest",
+		"docker.io/paketobuildpacks/ubuntu-noble-build:0.0.10":    "localhost:5000/ubuntu-noble-build:0.0.10",
+		"docker.io/paketobuildpacks/ubuntu-noble-run-tiny:0.0.10": "ghcr.io/gauron99/ubuntu-noble-run-tiny:0.0.10",
+		"docker.io/paketobuildpacks/noble-base-build:0.0.1":       "harbor.example.com/paketo/noble-base-build:0.0.1",
+	}
+	for src, want := range tests {
+		got, err := m.mapRef(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", src, got, want)
+		}
+	}
+
+	if _, err = m.mapRef("quay.io/someone/stack:1.0"); err == nil {
+		t.Error("expected error when no rule matches")
+	}
+}
+
+func TestImageMapperInvalidRules(t *testing.T) {
+	for _, rule := range []mirrorRule{
+		{Destination: "localhost:5000/x"},
+		{Prefix: "a/", Regex: "^b", Destination: "localhost:5000/x"},
+		{Regex: "(", Destination: "localhost:5000/x"},
+		{Prefix: "a/"},
+	} {
+		if _, err := newImageMapper([]mirrorRule{rule}); err == nil {
+			t.Errorf("expected error for %+v", rule)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/buildpacks/pack/builder"
+	"github.com/buildpacks/pack/buildpackage"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// buildProfile of an additional builder published next to the default one.
+//
+// Buildpacks of the profile are repackaged from their source release with
+// dependencies (JDKs, Maven and so on) included, so that the builder can be
+// used in clusters without access to the internet. They are replaced where
+// referenced directly by the builder or by one of the patched composite
+// buildpacks.
+type buildProfile struct {
+	// Name of the profile, tags of the builder are suffixed with it, e.g. "<version>-offline".
+	Name string `json:"name"`
+	// IDs of buildpacks packaged with dependencies, e.g. "paketo-buildpacks/bellsoft-liberica".
+	Buildpacks []string `json:"buildpacks"`
+	// Regular expressions matched against ID or version of dependencies,
+	// only matching dependencies are included. All are included when empty.
+	DependencyFilters []string `json:"dependencyFilters,omitempty"`
+	// Filters have to match both ID and version of dependencies.
+	StrictDependencyFilters bool `json:"strictDependencyFilters,omitempty"`
+}
+
+// suffix of the tags of the builder and of the buildpacks built for the profile
+func (p *buildProfile) tagSuffix() string {
+	if p == nil {
+		return ""
+	}
+	return "-" + p.Name
+}
+
+// returns the buildpack ID of the profile the image reference belongs to and the version (tag) of the image
+func (p *buildProfile) match(uri string) (string, string, bool) {
+	if p == nil || !strings.HasPrefix(uri, dockerScheme) {
+		return "", "", false
+	}
+	ref, err := name.NewTag(strings.TrimPrefix(uri, dockerScheme))
+	if err != nil {
+		return "", "", false
+	}
+	repoName := buildpackName(ref.RepositoryStr())
+	i := slices.IndexFunc(p.Buildpacks, func(id string) bool {
+		return buildpackName(id) == repoName
+	})
+	if i < 0 {
+		return "", "", false
+	}
+	return p.Buildpacks[i], ref.TagStr(), true
+}
+
+// builds the buildpack referenced by uri with dependencies included if it belongs to the profile,
+// returns URI of the image built or "" if the buildpack does not belong to the profile
+func (st *variantState) offlineBuildpack(ctx context.Context, uri, arch string) (string, error) {
+	id, version, ok := st.profile.match(uri)
+	if !ok {
+		return "", nil
+	}
+	key := arch + " " + uri
+	if img, ok := st.offline[key]; ok {
+		return dockerScheme + img, nil
+	}
+
+	bp := buildpackName(id)
+	img, err := st.buildBuildpack(ctx, buildpack{
+		repo:                    bp,
+		version:                 version,
+		image:                   "ghcr.io/gauron99/buildpacks/" + bp,
+		tagSuffix:               st.profile.tagSuffix(),
+		includeDependencies:     true,
+		dependencyFilters:       st.profile.DependencyFilters,
+		strictDependencyFilters: st.profile.StrictDependencyFilters,
+	}, arch)
+	if err != nil {
+		return "", fmt.Errorf("cannot build %q buildpack with dependencies: %w", bp, err)
+	}
+	st.offline[key] = img
+	return dockerScheme + img, nil
+}
+
+// replaces dependencies of a composite buildpack with the ones of the profile, returns the images built
+func (st *variantState) offlinePackageConfig(ctx context.Context, cfg *buildpackage.Config, arch string) ([]string, error) {
+	var images []string
+	for i, dep := range cfg.Dependencies {
+		uri, err := st.offlineBuildpack(ctx, dep.URI, arch)
+		if err != nil {
+			return nil, err
+		}
+		if uri == "" {
+			continue
+		}
+		cfg.Dependencies[i].URI = uri
+		images = append(images, strings.TrimPrefix(uri, dockerScheme))
+	}
+	return images, nil
+}
+
+// replaces buildpacks of the builder with the ones of the profile, returns the images built
+func (st *variantState) offlineBuilderConfig(ctx context.Context, cfg *builder.Config, arch string) ([]string, error) {
+	var images []string
+	for i, bp := range cfg.Buildpacks {
+		uri, err := st.offlineBuildpack(ctx, bp.URI, arch)
+		if err != nil {
+			return nil, err
+		}
+		if uri == "" {
+			continue
+		}
+		cfg.Buildpacks[i].URI = uri
+		images = append(images, strings.TrimPrefix(uri, dockerScheme))
+	}
+	return images, nil
+}
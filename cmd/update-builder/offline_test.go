@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestBuildProfileMatch(t *testing.T) {
+	p := &buildProfile{
+		Name:       "offline",
+		Buildpacks: []string{"paketo-buildpacks/bellsoft-liberica", "paketo-buildpacks/maven"},
+	}
+	if got := p.tagSuffix(); got != "-offline" {
+		t.Errorf("unexpected suffix %q", got)
+	}
+
+	id, version, ok := p.match("docker://gcr.io/paketo-buildpacks/bellsoft-liberica:10.8.1")
+	if !ok || id != "paketo-buildpacks/bellsoft-liberica" || version != "10.8.1" {
+		t.Errorf("got %q, %q, %v", id, version, ok)
+	}
+	if _, _, ok = p.match("docker://docker.io/paketobuildpacks/maven:6.19.2"); !ok {
+		t.Error("expected maven from docker.io to match")
+	}
+	for _, uri := range []string{
+		"docker://gcr.io/paketo-buildpacks/gradle:7.1.0",
+		"gcr.io/paketo-buildpacks/maven:6.19.2",
+		"docker://gcr.io/paketo-buildpacks/maven@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+	} {
+		if _, _, ok = p.match(uri); ok {
+			t.Errorf("%q should not match", uri)
+		}
+	}
+
+	var none *buildProfile
+	if none.tagSuffix() != "" {
+		t.Error("default profile should not have a suffix")
+	}
+	if _, _, ok = none.match("docker://gcr.io/paketo-buildpacks/maven:6.19.2"); ok {
+		t.Error("default profile should not match anything")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/buildpacks/pack/buildpackage"
+	"github.com/buildpacks/pack/pkg/dist"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Operations supported by orderPatch.
+const (
+	opInsertBefore = "insert-before"
+	opInsertAfter  = "insert-after"
+	opRemove       = "remove"
+	opReplace      = "replace"
+	opOptional     = "optional"
+)
+
+// compositePatch describes how composite buildpacks from the upstream builder
+// are rebuilt. Every buildpack listed in Buildpacks is downloaded, its
+// buildpack.toml order is patched by Operations and the result replaces the
+// upstream image in the builder.
+type compositePatch struct {
+	// IDs of the composite buildpacks, e.g. "paketo-buildpacks/java".
+	Buildpacks []string     `json:"buildpacks"`
+	Operations []orderPatch `json:"operations"`
+}
+
+// orderPatch is a single change to the order of a composite buildpack.
+//
+// Target is the ID of the module the operation is relative to. Groups limits
+// the operation to the given order groups (indexes into buildpack.toml order),
+// all groups containing Target are patched when empty.
+type orderPatch struct {
+	Op     string      `json:"op"`
+	Target string      `json:"target"`
+	Groups []int       `json:"groups,omitempty"`
+	Module patchModule `json:"module,omitempty"`
+}
+
+// patchModule is the module inserted by insert-before, insert-after or replace.
+type patchModule struct {
+	ID      string      `json:"id"`
+	Version versionSpec `json:"version"`
+	// URI added to dependencies in package.toml, "{version}" is substituted.
+	URI      string `json:"uri,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// returns whether the package.toml dependency is the module, e.g. "docker://docker.io/paketobuildpacks/maven:6.15.0"
+// or "urn:cnb:registry:paketo-buildpacks/maven@6.15.0" for "paketo-buildpacks/maven"
+func isDependencyOf(uri, id string) bool {
+	if rest, ok := strings.CutPrefix(uri, "urn:cnb:registry:"); ok {
+		depID, _, _ := strings.Cut(rest, "@")
+		return depID == id
+	}
+	ref, err := name.ParseReference(strings.TrimPrefix(uri, dockerScheme))
+	if err != nil {
+		return false
+	}
+	return path.Base(ref.Context().RepositoryStr()) == buildpackName(id)
+}
+
+func expandVersion(uri, version string) string {
+	return strings.ReplaceAll(uri, "{version}", version)
+}
+
+// name of the buildpack without the namespace, e.g. "java" for "paketo-buildpacks/java"
+func buildpackName(id string) string {
+	return path.Base(id)
+}
+
+func (p compositePatch) appliesTo(id string) bool {
+	return slices.Contains(p.Buildpacks, id)
+}
+
+func (p compositePatch) validate() error {
+	if len(p.Buildpacks) == 0 {
+		return fmt.Errorf("no buildpacks to patch")
+	}
+	for _, op := range p.Operations {
+		err := op.validate()
+		if err != nil {
+			return fmt.Errorf("invalid %q of %q: %w", op.Op, op.Target, err)
+		}
+	}
+	return nil
+}
+
+func (op orderPatch) validate() error {
+	switch op.Op {
+	case opInsertBefore, opInsertAfter, opReplace:
+		if op.Module.ID == "" {
+			return fmt.Errorf("module ID is not set")
+		}
+	case opRemove, opOptional:
+	default:
+		return fmt.Errorf("unknown operation, expected %q, %q, %q, %q or %q",
+			opInsertBefore, opInsertAfter, opRemove, opReplace, opOptional)
+	}
+	if op.Target == "" {
+		return fmt.Errorf("target is not set")
+	}
+	for _, g := range op.Groups {
+		if g < 0 {
+			return fmt.Errorf("negative order group %d", g)
+		}
+	}
+	return nil
+}
+
+// applies the operations to the order in bpDesc and adds dependencies of inserted modules to packageDesc,
+// versions of inserted modules are taken from versions (module ID -> version)
+func applyOrderPatches(ops []orderPatch, versions map[string]string, packageDesc *buildpackage.Config, bpDesc *dist.BuildpackDescriptor) error {
+	for _, op := range ops {
+		err := applyOrderPatch(op, versions[op.Module.ID], packageDesc, bpDesc)
+		if err != nil {
+			return fmt.Errorf("cannot apply %q of %q: %w", op.Op, op.Target, err)
+		}
+	}
+	return nil
+}
+
+func applyOrderPatch(op orderPatch, version string, packageDesc *buildpackage.Config, bpDesc *dist.BuildpackDescriptor) error {
+	for _, g := range op.Groups {
+		if g < 0 || g >= len(bpDesc.WithOrder) {
+			return fmt.Errorf("order group %d out of range", g)
+		}
+	}
+
+	ref := dist.ModuleRef{
+		ModuleInfo: dist.ModuleInfo{
+			ID:      op.Module.ID,
+			Version: version,
+		},
+		Optional: op.Module.Optional,
+	}
+
+	var patched bool
+	for i := range bpDesc.WithOrder {
+		if len(op.Groups) > 0 && !slices.Contains(op.Groups, i) {
+			continue
+		}
+		group := bpDesc.WithOrder[i].Group
+		idx := slices.IndexFunc(group, func(ref dist.ModuleRef) bool {
+			return ref.ID == op.Target
+		})
+		if idx < 0 {
+			continue
+		}
+		switch op.Op {
+		case opInsertBefore:
+			group = slices.Insert(group, idx, ref)
+		case opInsertAfter:
+			group = slices.Insert(group, idx+1, ref)
+		case opRemove:
+			group = slices.Delete(group, idx, idx+1)
+		case opReplace:
+			group[idx] = ref
+		case opOptional:
+			group[idx].Optional = true
+		default:
+			return fmt.Errorf("unknown operation")
+		}
+		bpDesc.WithOrder[i].Group = group
+		patched = true
+	}
+	if !patched {
+		return fmt.Errorf("module not found in order")
+	}
+
+	// the package would still ship the module that is not in any group any more
+	if (op.Op == opRemove || op.Op == opReplace) && !orderContains(bpDesc.WithOrder, op.Target) {
+		packageDesc.Dependencies = slices.DeleteFunc(packageDesc.Dependencies, func(dep dist.ImageOrURI) bool {
+			return isDependencyOf(dep.URI, op.Target)
+		})
+	}
+
+	if op.Module.URI != "" && (op.Op == opInsertBefore || op.Op == opInsertAfter || op.Op == opReplace) {
+		uri := expandVersion(op.Module.URI, version)
+		hasDep := slices.ContainsFunc(packageDesc.Dependencies, func(dep dist.ImageOrURI) bool {
+			return dep.URI == uri
+		})
+		if !hasDep {
+			packageDesc.Dependencies = append(packageDesc.Dependencies, dist.ImageOrURI{
+				BuildpackURI: dist.BuildpackURI{URI: uri},
+			})
+		}
+	}
+	return nil
+}
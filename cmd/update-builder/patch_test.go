@@ -0,0 +1,212 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/buildpacks/pack/buildpackage"
+	"github.com/buildpacks/pack/pkg/dist"
+)
+
+func javaDescriptor() dist.BuildpackDescriptor {
+	return dist.BuildpackDescriptor{
+		WithOrder: dist.Order{
+			{Group: []dist.ModuleRef{
+				{ModuleInfo: dist.ModuleInfo{ID: "paketo-buildpacks/ca-certificates"}},
+				{ModuleInfo: dist.ModuleInfo{ID: "paketo-buildpacks/maven"}, Optional: false},
+				{ModuleInfo: dist.ModuleInfo{ID: "paketo-buildpacks/gradle"}},
+			}},
+			{Group: []dist.ModuleRef{
+				{ModuleInfo: dist.ModuleInfo{ID: "paketo-buildpacks/maven"}},
+			}},
+		},
+	}
+}
+
+func groupIDs(g []dist.ModuleRef) []string {
+	var ids []string
+	for _, r := range g {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func TestApplyOrderPatches(t *testing.T) {
+	versions := map[string]string{"paketo-buildpacks/quarkus": "1.2.3"}
+	quarkus := patchModule{
+		ID:       "paketo-buildpacks/quarkus",
+		URI:      "docker://index.docker.io/paketobuildpacks/quarkus:{version}",
+		Optional: true,
+	}
+
+	tests := []struct {
+		name   string
+		op     orderPatch
+		groups [][]string
+	}{
+		{
+			name: "insert before in first group",
+			op:   orderPatch{Op: opInsertBefore, Target: "paketo-buildpacks/maven", Groups: []int{0}, Module: quarkus},
+			groups: [][]string{
+				{"paketo-buildpacks/ca-certificates", "paketo-buildpacks/quarkus", "paketo-buildpacks/maven", "paketo-buildpacks/gradle"},
+				{"paketo-buildpacks/maven"},
+			},
+		},
+		{
+			name: "insert after in all groups",
+			op:   orderPatch{Op: opInsertAfter, Target: "paketo-buildpacks/maven", Module: quarkus},
+			groups: [][]string{
+				{"paketo-buildpacks/ca-certificates", "paketo-buildpacks/maven", "paketo-buildpacks/quarkus", "paketo-buildpacks/gradle"},
+				{"paketo-buildpacks/maven", "paketo-buildpacks/quarkus"},
+			},
+		},
+		{
+			name: "remove",
+			op:   orderPatch{Op: opRemove, Target: "paketo-buildpacks/gradle"},
+			groups: [][]string{
+				{"paketo-buildpacks/ca-certificates", "paketo-buildpacks/maven"},
+				{"paketo-buildpacks/maven"},
+			},
+		},
+		{
+			name: "replace",
+			op:   orderPatch{Op: opReplace, Target: "paketo-buildpacks/maven", Groups: []int{1}, Module: quarkus},
+			groups: [][]string{
+				{"paketo-buildpacks/ca-certificates", "paketo-buildpacks/maven", "paketo-buildpacks/gradle"},
+				{"paketo-buildpacks/quarkus"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bpDesc := javaDescriptor()
+			var packageDesc buildpackage.Config
+			err := applyOrderPatches([]orderPatch{tt.op}, versions, &packageDesc, &bpDesc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range tt.groups {
+				if got := groupIDs(bpDesc.WithOrder[i].Group); !slices.Equal(got, want) {
+					t.Errorf("group %d: got %v, want %v", i, got, want)
+				}
+			}
+			if tt.op.Module.URI != "" {
+				if len(packageDesc.Dependencies) != 1 || packageDesc.Dependencies[0].URI != "docker://index.docker.io/paketobuildpacks/quarkus:1.2.3" {
+					t.Errorf("unexpected dependencies: %+v", packageDesc.Dependencies)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyOrderPatchesOptional(t *testing.T) {
+	bpDesc := javaDescriptor()
+	var packageDesc buildpackage.Config
+	err := applyOrderPatches([]orderPatch{{Op: opOptional, Target: "paketo-buildpacks/maven", Groups: []int{0}}}, nil, &packageDesc, &bpDesc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bpDesc.WithOrder[0].Group[1].Optional {
+		t.Error("maven should be optional in first group")
+	}
+	if bpDesc.WithOrder[1].Group[0].Optional {
+		t.Error("maven should not be optional in second group")
+	}
+}
+
+func TestApplyOrderPatchesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		op   orderPatch
+	}{
+		{name: "missing target", op: orderPatch{Op: opRemove, Target: "paketo-buildpacks/sbt"}},
+		{name: "group out of range", op: orderPatch{Op: opRemove, Target: "paketo-buildpacks/maven", Groups: []int{2}}},
+		{name: "unknown operation", op: orderPatch{Op: "move", Target: "paketo-buildpacks/maven"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bpDesc := javaDescriptor()
+			var packageDesc buildpackage.Config
+			if err := applyOrderPatches([]orderPatch{tt.op}, nil, &packageDesc, &bpDesc); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestApplyOrderPatchesDropsDependencies(t *testing.T) {
+	deps := func() *buildpackage.Config {
+		return &buildpackage.Config{Dependencies: []dist.ImageOrURI{
+			{BuildpackURI: dist.BuildpackURI{URI: "docker://docker.io/paketobuildpacks/ca-certificates:3.6.0"}},
+			{BuildpackURI: dist.BuildpackURI{URI: "docker://docker.io/paketobuildpacks/maven:6.15.0"}},
+			{BuildpackURI: dist.BuildpackURI{URI: "urn:cnb:registry:paketo-buildpacks/gradle@7.9.0"}},
+		}}
+	}
+	depURIs := func(cfg *buildpackage.Config) []string {
+		var uris []string
+		for _, dep := range cfg.Dependencies {
+			uris = append(uris, dep.URI)
+		}
+		return uris
+	}
+
+	tests := []struct {
+		name string
+		op   orderPatch
+		want []string
+	}{
+		{
+			name: "removed from all groups",
+			op:   orderPatch{Op: opRemove, Target: "paketo-buildpacks/gradle"},
+			want: []string{"docker://docker.io/paketobuildpacks/ca-certificates:3.6.0", "docker://docker.io/paketobuildpacks/maven:6.15.0"},
+		},
+		{
+			name: "still in another group",
+			op:   orderPatch{Op: opRemove, Target: "paketo-buildpacks/maven", Groups: []int{0}},
+			want: []string{"docker://docker.io/paketobuildpacks/ca-certificates:3.6.0", "docker://docker.io/paketobuildpacks/maven:6.15.0", "urn:cnb:registry:paketo-buildpacks/gradle@7.9.0"},
+		},
+		{
+			name: "replaced",
+			op: orderPatch{Op: opReplace, Target: "paketo-buildpacks/maven", Module: patchModule{
+				ID:  "paketo-buildpacks/quarkus",
+				URI: "docker://docker.io/paketobuildpacks/quarkus:{version}",
+			}},
+			want: []string{"docker://docker.io/paketobuildpacks/ca-certificates:3.6.0", "urn:cnb:registry:paketo-buildpacks/gradle@7.9.0", "docker://docker.io/paketobuildpacks/quarkus:1.2.3"},
+		},
+	}
+	for _, tt := range tests {
+		bpDesc := javaDescriptor()
+		packageDesc := deps()
+		err := applyOrderPatches([]orderPatch{tt.op}, map[string]string{"paketo-buildpacks/quarkus": "1.2.3"}, packageDesc, &bpDesc)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := depURIs(packageDesc); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: dependencies = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOrderPatchValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		op   orderPatch
+		ok   bool
+	}{
+		{name: "insert", op: orderPatch{Op: opInsertBefore, Target: "paketo-buildpacks/maven", Module: patchModule{ID: "paketo-buildpacks/quarkus"}}, ok: true},
+		{name: "remove", op: orderPatch{Op: opRemove, Target: "paketo-buildpacks/gradle", Groups: []int{0, 1}}, ok: true},
+		{name: "unknown op", op: orderPatch{Op: "insert", Target: "paketo-buildpacks/maven"}},
+		{name: "no target", op: orderPatch{Op: opOptional}},
+		{name: "no module", op: orderPatch{Op: opReplace, Target: "paketo-buildpacks/maven"}},
+		{name: "negative group", op: orderPatch{Op: opRemove, Target: "paketo-buildpacks/gradle", Groups: []int{-1}}},
+	}
+	for _, tt := range tests {
+		err := tt.op.validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+	if err := (compositePatch{Operations: []orderPatch{tests[0].op}}).validate(); err == nil {
+		t.Error("expected error for patch without buildpacks")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/buildpacks/pack/builder"
+	"github.com/buildpacks/pack/buildpackage"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+const dockerScheme = "docker://"
+
+// digestPinner rewrites image references to immutable digest references.
+// References are resolved only once, so all arches of a variant use the same digests.
+type digestPinner struct {
+	remoteOpts []remote.Option
+	// reference -> reference pinned to digest
+	pinned map[string]string
+}
+
+func newDigestPinner(remoteOpts []remote.Option) *digestPinner {
+	return &digestPinner{
+		remoteOpts: remoteOpts,
+		pinned:     make(map[string]string),
+	}
+}
+
+// returns image reference (optionally with docker:// scheme) pinned to the digest the tag currently points to
+func (p *digestPinner) pin(ref string) (string, error) {
+	if pinned, ok := p.pinned[ref]; ok {
+		return pinned, nil
+	}
+
+	scheme := ""
+	img := ref
+	if strings.HasPrefix(ref, dockerScheme) {
+		scheme, img = dockerScheme, strings.TrimPrefix(ref, dockerScheme)
+	}
+	r, err := name.ParseReference(img)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse reference %q: %w", ref, err)
+	}
+	if _, ok := r.(name.Digest); ok {
+		return ref, nil
+	}
+	desc, err := remote.Head(r, p.remoteOpts...)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve digest of %q: %w", ref, err)
+	}
+	pinned := scheme + r.Context().Name() + "@" + desc.Digest.String()
+	fmt.Printf("## pinned: '%v' -> '%v'\n", ref, pinned)
+	p.pinned[ref] = pinned
+	return pinned, nil
+}
+
+// pins buildpacks and stack images of the builder, images in local exist only in the daemon and are kept as they are
+func (p *digestPinner) pinBuilderConfig(cfg *builder.Config, local []string) error {
+	var err error
+	for i, bp := range cfg.Buildpacks {
+		if !strings.HasPrefix(bp.URI, dockerScheme) || slices.Contains(local, strings.TrimPrefix(bp.URI, dockerScheme)) {
+			continue
+		}
+		cfg.Buildpacks[i].URI, err = p.pin(bp.URI)
+		if err != nil {
+			return err
+		}
+	}
+	if strings.HasPrefix(cfg.Lifecycle.URI, dockerScheme) {
+		cfg.Lifecycle.URI, err = p.pin(cfg.Lifecycle.URI)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, img := range []*string{&cfg.Stack.BuildImage, &cfg.Stack.RunImage, &cfg.Build.Image} {
+		if *img == "" {
+			continue
+		}
+		*img, err = p.pin(*img)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range cfg.Run.Images {
+		cfg.Run.Images[i].Image, err = p.pin(cfg.Run.Images[i].Image)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pins dependencies of a composite buildpack, images in local exist only in the daemon and are kept as they are
+func (p *digestPinner) pinPackageConfig(cfg *buildpackage.Config, local []string) error {
+	var err error
+	for i, dep := range cfg.Dependencies {
+		if !strings.HasPrefix(dep.URI, dockerScheme) || slices.Contains(local, strings.TrimPrefix(dep.URI, dockerScheme)) {
+			continue
+		}
+		cfg.Dependencies[i].URI, err = p.pin(dep.URI)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/buildpacks/pack/builder"
+	"github.com/buildpacks/pack/pkg/dist"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestPinBuilderConfig(t *testing.T) {
+	reg := testRegistry(t)
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{"/rust:0.65.0", "/run:1.0.0"} {
+		pushTestImage(t, reg+tag, img)
+	}
+
+	cfg := builder.Config{
+		Buildpacks: builder.ModuleCollection{
+			{ImageOrURI: dist.ImageOrURI{BuildpackURI: dist.BuildpackURI{URI: "docker://" + reg + "/rust:0.65.0"}}},
+			{ImageOrURI: dist.ImageOrURI{BuildpackURI: dist.BuildpackURI{URI: "docker://ghcr.io/gauron99/buildpacks/java:1.0.0"}}},
+		},
+		Stack: builder.StackConfig{RunImage: reg + "/run:1.0.0"},
+	}
+
+	p := newDigestPinner(nil)
+	err = p.pinBuilderConfig(&cfg, []string{"ghcr.io/gauron99/buildpacks/java:1.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "docker://" + reg + "/rust@" + digest.String(); cfg.Buildpacks[0].URI != want {
+		t.Errorf("got %q, want %q", cfg.Buildpacks[0].URI, want)
+	}
+	if want := "docker://ghcr.io/gauron99/buildpacks/java:1.0.0"; cfg.Buildpacks[1].URI != want {
+		t.Errorf("local image should not be pinned, got %q", cfg.Buildpacks[1].URI)
+	}
+	if want := reg + "/run@" + digest.String(); cfg.Stack.RunImage != want {
+		t.Errorf("got %q, want %q", cfg.Stack.RunImage, want)
+	}
+	if len(p.pinned) != 2 {
+		t.Errorf("expected 2 pinned references, got %v", p.pinned)
+	}
+}
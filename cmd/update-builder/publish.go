@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Returns tag the index released as tag is staged under. It is the same for each run of the release,
+// so that registries that do not support deleting tags, like GHCR, keep one staging tag overwritten by the next run.
+func stagingTag(tag name.Tag) name.Tag {
+	return tag.Context().Tag(tag.TagStr() + "-staging")
+}
+
+// Publishes the index under the tags. The index is written to a staging tag first and
+// the tags are moved to it only if it passes inspection, so a broken index is never
+// visible under the tags. beforePromote is called once the index is verified.
+// The staging tag is deleted afterwards.
+func publishIndex(cfg *config, idx v1.ImageIndex, tags []name.Tag, beforePromote func() error, remoteOpts ...remote.Option) error {
+	fmt.Println("#### publishIndex")
+	if len(tags) == 0 {
+		return fmt.Errorf("no tags to publish to")
+	}
+	staging := stagingTag(tags[0])
+	err := remote.WriteIndex(staging, idx, remoteOpts...)
+	if err != nil {
+		return fmt.Errorf("cannot write staging index: %w", err)
+	}
+	fmt.Printf("## staged: '%v'\n", staging)
+	defer func() {
+		// registries that do not support deleting tags keep the staging tag until the next run overwrites it
+		err := remote.Delete(staging, remoteOpts...)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "cannot delete staging tag %s, it is kept: %v\n", staging, err)
+		}
+	}()
+
+	report, err := inspectBuilder(cfg, staging, remoteOpts...)
+	if err != nil {
+		return fmt.Errorf("cannot inspect staging index: %w", err)
+	}
+	report.print(os.Stdout)
+	if report.failed() {
+		return fmt.Errorf("staging index %s did not pass inspection", staging)
+	}
+
+	if beforePromote != nil {
+		err = beforePromote()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, tag := range tags {
+		err = remote.Tag(tag, idx, remoteOpts...)
+		if err != nil {
+			return fmt.Errorf("cannot tag index as %s: %w", tag, err)
+		}
+		fmt.Printf("## promoted: '%v' -> '%v'\n", staging, tag)
+	}
+	return nil
+}
+
+// returns repositories the builder is published to, the first one is the primary repository
+// signatures, release notes and downstream PRs refer to
+func builderRepos(cfg *config, distro, variant string) ([]name.Repository, error) {
+	if len(cfg.Destinations) == 0 {
+		return nil, fmt.Errorf("no destinations to publish to")
+	}
+	repos := make([]name.Repository, 0, len(cfg.Destinations))
+	for _, dest := range cfg.Destinations {
+		repo, err := name.NewRepository(strings.TrimSuffix(dest, "/") + "/" + builderName(distro, variant))
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination %q: %w", dest, err)
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// Publishes the index already present in the primary repository under the tag to the repositories
+// that do not have it, e.g. destinations added to the configuration or failed in the previous run.
+// Attestations are attached only by the run that built the index, they are not copied.
+func backfillIndex(cfg *config, idx v1.ImageIndex, repos []name.Repository, tag string, signingKey *ecdsa.PrivateKey, remoteOpts ...remote.Option) error {
+	for _, repo := range repos {
+		_, err := remote.Head(repo.Tag(tag), remoteOpts...)
+		if err == nil {
+			continue
+		}
+		if !isNotFound(err) {
+			return fmt.Errorf("cannot check index in %s: %w", repo, err)
+		}
+		fmt.Printf("## backfilling: '%v'\n", repo.Tag(tag))
+		err = publishIndex(cfg, idx, []name.Tag{repo.Tag(tag)}, func() error {
+			if signingKey == nil {
+				return nil
+			}
+			return signIndex(signingKey, repo, idx, remoteOpts...)
+		}, remoteOpts...)
+		if err != nil {
+			return fmt.Errorf("cannot publish index to %s: %w", repo, err)
+		}
+	}
+	return nil
+}
+
+// Checks that all tags serve the index with the digest. A registry serving different digest
+// rewrote the manifests, e.g. converted their media types.
+func checkDigestParity(tags []name.Tag, digest v1.Hash, remoteOpts ...remote.Option) error {
+	fmt.Println("#### checkDigestParity")
+	var problems []string
+	for _, tag := range tags {
+		desc, err := remote.Head(tag, remoteOpts...)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", tag, err))
+		case desc.Digest != digest:
+			problems = append(problems, fmt.Sprintf("%s serves %s, registry %s rewrote the manifests", tag, desc.Digest, tag.RegistryStr()))
+		default:
+			fmt.Printf("## digest: '%v' -> '%v'\n", tag, desc.Digest)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("destinations do not serve index %s:\n  %s", digest, strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// returns references of the tags pinned to the digest
+func publishedRefs(tags []name.Tag, digest v1.Hash) []string {
+	refs := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		refs = append(refs, tag.String()+"@"+digest.String())
+	}
+	return refs
+}
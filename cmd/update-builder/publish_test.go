@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestPublishIndex(t *testing.T) {
+	reg := testRegistry(t)
+
+	cfg := defaultConfig()
+	repo, err := name.NewRepository(reg + "/gauron99/builder-jammy-base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := []name.Tag{repo.Tag("v0.4.0"), repo.Tag("latest")}
+
+	t.Run("broken", func(t *testing.T) {
+		idx := testBuilderIndex(t, map[string]builderLabels{"amd64": testBuilderLabels(cfg)})
+		var called bool
+		err := publishIndex(&cfg, idx, tags, func() error {
+			called = true
+			return nil
+		})
+		if err == nil {
+			t.Fatal("expected error for index without arm64 builder")
+		}
+		if called {
+			t.Error("index was promoted")
+		}
+		for _, tag := range tags {
+			if _, err := remote.Head(tag); !isNotFound(err) {
+				t.Errorf("expected %s not to exist, got: %v", tag, err)
+			}
+		}
+	})
+
+	t.Run("failed-before-promote", func(t *testing.T) {
+		idx := testBuilderIndex(t, map[string]builderLabels{"amd64": testBuilderLabels(cfg), "arm64": testBuilderLabels(cfg)})
+		err := publishIndex(&cfg, idx, tags, func() error {
+			return errors.New("cannot sign")
+		})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if _, err := remote.Head(tags[0]); !isNotFound(err) {
+			t.Errorf("expected %s not to exist, got: %v", tags[0], err)
+		}
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		idx := testBuilderIndex(t, map[string]builderLabels{"amd64": testBuilderLabels(cfg), "arm64": testBuilderLabels(cfg)})
+		err := publishIndex(&cfg, idx, tags, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest, err := idx.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tag := range tags {
+			desc, err := remote.Head(tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if desc.Digest != digest {
+				t.Errorf("%s points to %s, expected %s", tag, desc.Digest, digest)
+			}
+		}
+		all, err := remote.List(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slices.ContainsFunc(all, func(tag string) bool {
+			return strings.HasSuffix(tag, "-staging")
+		}) {
+			t.Errorf("staging tags were not deleted: %v", all)
+		}
+	})
+}
+
+func TestPublishIndexWithoutComposite(t *testing.T) {
+	reg := testRegistry(t)
+	cfg := defaultConfig()
+
+	noComposite := testBuilderLabels(cfg)
+	noComposite.Order = noComposite.Order[:1]
+	noComposite.Layers = maps.Clone(noComposite.Layers)
+	delete(noComposite.Layers, "paketo-buildpacks/java")
+	delete(noComposite.Layers, "paketo-buildpacks/java-native-image")
+	for variant, bl := range map[string]builderLabels{"tiny": testTinyBuilderLabels(cfg), "static": noComposite} {
+		tag, err := name.NewTag(reg + "/gauron99/builder-jammy-" + variant + ":v0.4.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx := testVariantIndex(t, variant, map[string]builderLabels{"amd64": bl, "arm64": bl})
+		err = publishIndex(&cfg, idx, []name.Tag{tag}, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", variant, err)
+		}
+		if _, err := remote.Head(tag); err != nil {
+			t.Errorf("%s was not promoted: %v", tag, err)
+		}
+	}
+}
+
+func TestStagingTag(t *testing.T) {
+	tag, err := name.NewTag("ghcr.io/gauron99/builder-jammy-base:v0.4.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each run of the release overwrites the same staging tag instead of leaving a new one behind
+	if a, b := stagingTag(tag), stagingTag(tag); a != b || a.String() != "ghcr.io/gauron99/builder-jammy-base:v0.4.0-staging" {
+		t.Errorf("staging tags = %s, %s", a, b)
+	}
+}
+
+func TestBackfillAndDigestParity(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Destinations = nil
+	for range 3 {
+		cfg.Destinations = append(cfg.Destinations, testRegistry(t)+"/gauron99")
+	}
+	repos, err := builderRepos(&cfg, distroJammy, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repos[0].RepositoryStr() != "gauron99/builder-jammy-base" {
+		t.Errorf("repository = %s", repos[0].RepositoryStr())
+	}
+
+	bl := testBuilderLabels(cfg)
+	idx := testBuilderIndex(t, map[string]builderLabels{"amd64": bl, "arm64": bl})
+	digest, err := idx.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the primary and the last destination have the index already
+	for _, repo := range []name.Repository{repos[0], repos[2]} {
+		pushTestIndex(t, repo.Tag("v0.4.0").String(), idx)
+	}
+	tags := []name.Tag{repos[0].Tag("v0.4.0"), repos[1].Tag("v0.4.0"), repos[2].Tag("v0.4.0")}
+
+	err = checkDigestParity(tags, digest)
+	if err == nil || !strings.Contains(err.Error(), repos[1].RegistryStr()) {
+		t.Errorf("expected error for missing index, got: %v", err)
+	}
+
+	err = backfillIndex(&cfg, idx, repos[1:], "v0.4.0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checkDigestParity(tags, digest)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// registry serving another index under the tag
+	other := testBuilderIndex(t, map[string]builderLabels{"amd64": bl})
+	pushTestIndex(t, tags[2].String(), other)
+	err = checkDigestParity(tags, digest)
+	if err == nil || !strings.Contains(err.Error(), "rewrote the manifests") {
+		t.Errorf("expected error for rewritten index, got: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-github/v68/github"
+)
+
+// releaseNotes of a published builder, see newReleaseNotes.
+type releaseNotes struct {
+	Tag   string `json:"tag"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// returns notes of the release of the builder index published at idxRef.
+// Diff to the previously published builder is included when previous is not nil.
+func newReleaseNotes(idxRef name.Reference, idx v1.ImageIndex, upstream *github.RepositoryRelease, injected map[string]string, previous *builderSummary, current builderSummary) (releaseNotes, error) {
+	repo := path.Base(idxRef.Context().RepositoryStr())
+	notes := releaseNotes{
+		Tag:   repo + "-" + idxRef.Identifier(),
+		Title: repo + " " + idxRef.Identifier(),
+	}
+
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, "Builder `%s` built from upstream release [%s](%s).\n\n", idxRef, upstream.GetName(), upstream.GetHTMLURL())
+
+	digest, err := idx.Digest()
+	if err != nil {
+		return notes, fmt.Errorf("cannot get digest of the index: %w", err)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return notes, fmt.Errorf("cannot get index manifest: %w", err)
+	}
+	sb.WriteString("### Digests\n\n| Platform | Digest |\n|---|---|\n")
+	_, _ = fmt.Fprintf(&sb, "| index | `%s` |\n", digest)
+	for _, desc := range im.Manifests {
+		if desc.Platform == nil {
+			continue
+		}
+		_, _ = fmt.Fprintf(&sb, "| %s | `%s` |\n", desc.Platform, desc.Digest)
+	}
+	sb.WriteString("\n")
+
+	if len(injected) > 0 {
+		sb.WriteString("### Injected buildpacks\n\n| Buildpack | Version |\n|---|---|\n")
+		for _, id := range slices.Sorted(maps.Keys(injected)) {
+			_, _ = fmt.Fprintf(&sb, "| %s | %s |\n", id, injected[id])
+		}
+		sb.WriteString("\n")
+	}
+
+	if previous != nil {
+		sb.WriteString(diffBuilders(*previous, current))
+	}
+	notes.Body = sb.String()
+	return notes, nil
+}
+
+// creates release with the notes in the repository ("owner/name"), the release is updated if it already exists
+func publishRelease(ctx context.Context, client *github.Client, repository string, notes releaseNotes) error {
+	fmt.Println("#### publishRelease")
+	owner, repo, ok := strings.Cut(repository, "/")
+	if !ok {
+		return fmt.Errorf("invalid repository %q, expected <owner>/<name>", repository)
+	}
+
+	rel, resp, err := client.Repositories.GetReleaseByTag(ctx, owner, repo, notes.Tag)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return fmt.Errorf("cannot get release %q: %w", notes.Tag, err)
+	}
+
+	if rel != nil {
+		rel, _, err = client.Repositories.EditRelease(ctx, owner, repo, rel.GetID(), &github.RepositoryRelease{
+			Name: github.Ptr(notes.Title),
+			Body: github.Ptr(notes.Body),
+		})
+		if err != nil {
+			return fmt.Errorf("cannot update release %q: %w", notes.Tag, err)
+		}
+		fmt.Printf("## release updated: '%v'\n", rel.GetHTMLURL())
+		return nil
+	}
+
+	newRelease := &github.RepositoryRelease{
+		TagName: github.Ptr(notes.Tag),
+		Name:    github.Ptr(notes.Title),
+		Body:    github.Ptr(notes.Body),
+	}
+	if sha := os.Getenv("GITHUB_SHA"); sha != "" {
+		newRelease.TargetCommitish = github.Ptr(sha)
+	}
+	rel, _, err = client.Repositories.CreateRelease(ctx, owner, repo, newRelease)
+	if err != nil {
+		return fmt.Errorf("cannot create release %q: %w", notes.Tag, err)
+	}
+	fmt.Printf("## release created: '%v'\n", rel.GetHTMLURL())
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-github/v68/github"
+)
+
+// fake GitHub API keeping releases of a single repository in memory
+type fakeReleases struct {
+	releases map[string]*github.RepositoryRelease
+}
+
+func (f *fakeReleases) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const prefix = "/repos/gauron99/actions-testing/releases"
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, prefix+"/tags/"):
+		rel, ok := f.releases[strings.TrimPrefix(r.URL.Path, prefix+"/tags/")]
+		if !ok {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(rel)
+	case r.Method == http.MethodPost && r.URL.Path == prefix:
+		var rel github.RepositoryRelease
+		_ = json.NewDecoder(r.Body).Decode(&rel)
+		rel.ID = github.Ptr(int64(len(f.releases) + 1))
+		f.releases[rel.GetTagName()] = &rel
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(rel)
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, prefix+"/"):
+		var edit github.RepositoryRelease
+		_ = json.NewDecoder(r.Body).Decode(&edit)
+		for _, rel := range f.releases {
+			if r.URL.Path == prefix+"/"+github.Stringify(rel.GetID()) {
+				rel.Body = edit.Body
+				_ = json.NewEncoder(w).Encode(rel)
+				return
+			}
+		}
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+	default:
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+	}
+}
+
+func TestPublishRelease(t *testing.T) {
+	fake := &fakeReleases{releases: make(map[string]*github.RepositoryRelease)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	idxRef, err := name.ParseReference("ghcr.io/gauron99/builder-jammy-base:v0.4.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := random.Index(64, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := &github.RepositoryRelease{
+		Name:    github.Ptr("v0.4.0"),
+		HTMLURL: github.Ptr("https://github.com/paketo-buildpacks/builder-jammy-base/releases/tag/v0.4.0"),
+	}
+	notes, err := newReleaseNotes(idxRef, idx, upstream, map[string]string{"paketo-community/rust": "0.65.0"}, nil, builderSummary{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notes.Tag != "builder-jammy-base-v0.4.0" {
+		t.Errorf("unexpected tag %q", notes.Tag)
+	}
+	digest, _ := idx.Digest()
+	for _, want := range []string{"| index | `" + digest.String() + "` |", "| paketo-community/rust | 0.65.0 |"} {
+		if !strings.Contains(notes.Body, want) {
+			t.Errorf("notes do not contain %q:\n%s", want, notes.Body)
+		}
+	}
+
+	err = publishRelease(context.Background(), client, "gauron99/actions-testing", notes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, ok := fake.releases[notes.Tag]
+	if !ok || rel.GetBody() != notes.Body {
+		t.Fatalf("release not created: %v", fake.releases)
+	}
+
+	notes.Body = "updated"
+	err = publishRelease(context.Background(), client, "gauron99/actions-testing", notes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.releases) != 1 || fake.releases[notes.Tag].GetBody() != "updated" {
+		t.Errorf("release not updated: %v", fake.releases)
+	}
+}
+
+func TestReleaseStageResumed(t *testing.T) {
+	fake := &fakeReleases{releases: make(map[string]*github.RepositoryRelease)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	cfg := defaultConfig()
+	cfg.ReleaseRepo = "gauron99/actions-testing"
+	cp, err := loadCheckpoint("", false, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the run published the index but failed to create the release
+	vc := cp.variant("jammy-base")
+	vc.Published = "ghcr.io/gauron99/builder-jammy-base:v0.4.0@sha256:abc"
+	vc.Notes = &releaseNotes{Tag: "builder-jammy-base-v0.4.0", Title: "builder-jammy-base v0.4.0", Body: "notes"}
+
+	err = releaseStage(context.Background(), &cfg, cp, vc, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vc.Released || fake.releases["builder-jammy-base-v0.4.0"].GetBody() != "notes" {
+		t.Errorf("release not created: %+v", fake.releases)
+	}
+
+	delete(fake.releases, "builder-jammy-base-v0.4.0")
+	err = releaseStage(context.Background(), &cfg, cp, vc, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.releases) != 0 {
+		t.Error("release created again")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/buildpacks/pack/builder"
+	"github.com/buildpacks/pack/buildpackage"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+const lifecycleImage = "docker.io/buildpacksio/lifecycle"
+
+// relocator copies images the builder needs into a registry namespace we control
+// and rewrites references to point there, so the build does not pull from upstream registries.
+//
+// An image "docker.io/paketobuildpacks/java:18.9.0" is relocated to "<namespace>/docker.io/paketobuildpacks/java:18.9.0",
+// the registry is kept in the path so that images of the same path in different registries do not overwrite each other.
+type relocator struct {
+	namespace string
+	copyImage func(ctx context.Context, srcRef, destRef string) error
+	// source reference -> relocated reference
+	relocated map[string]string
+}
+
+func newRelocator(namespace string) *relocator {
+	return &relocator{
+		namespace: strings.TrimSuffix(namespace, "/"),
+		copyImage: copyImage,
+		relocated: make(map[string]string),
+	}
+}
+
+// returns the reference the image is relocated to, without copying it
+func (r *relocator) destination(ref string) (string, error) {
+	scheme := ""
+	img := ref
+	if strings.HasPrefix(ref, dockerScheme) {
+		scheme, img = dockerScheme, strings.TrimPrefix(ref, dockerScheme)
+	}
+	src, err := name.ParseReference(img)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse reference %q: %w", ref, err)
+	}
+	reg := src.Context().RegistryStr()
+	if reg == name.DefaultRegistry {
+		reg = "docker.io"
+	}
+	// port is not allowed in repository path
+	dest := r.namespace + "/" + strings.ReplaceAll(reg, ":", "-") + "/" + src.Context().RepositoryStr()
+	switch src := src.(type) {
+	case name.Digest:
+		dest += "@" + src.DigestStr()
+	default:
+		dest += ":" + src.Identifier()
+	}
+	return scheme + dest, nil
+}
+
+// copies the image into the namespace and returns the relocated reference
+func (r *relocator) relocate(ctx context.Context, ref string) (string, error) {
+	if dest, ok := r.relocated[ref]; ok {
+		return dest, nil
+	}
+	dest, err := r.destination(ref)
+	if err != nil {
+		return "", err
+	}
+	srcImg := strings.TrimPrefix(ref, dockerScheme)
+	destImg := strings.TrimPrefix(dest, dockerScheme)
+	copyDest := destImg
+	if repo, digest, ok := strings.Cut(destImg, "@"); ok {
+		// registries cannot be pushed to by digest, the digest is kept by the copy
+		copyDest = repo + ":" + strings.ReplaceAll(digest, ":", "-")
+	}
+	err = r.copyImage(ctx, srcImg, copyDest)
+	if err != nil {
+		return "", fmt.Errorf("cannot relocate %q: %w", ref, err)
+	}
+	r.relocated[ref] = dest
+	return dest, nil
+}
+
+// relocates buildpacks and lifecycle of the builder, images in local exist only in the daemon and are kept as they are.
+// Stack images are relocated by buildStack.
+func (r *relocator) relocateBuilderConfig(ctx context.Context, cfg *builder.Config, local []string) error {
+	var err error
+	for i, bp := range cfg.Buildpacks {
+		if !strings.HasPrefix(bp.URI, dockerScheme) || slices.Contains(local, strings.TrimPrefix(bp.URI, dockerScheme)) {
+			continue
+		}
+		cfg.Buildpacks[i].URI, err = r.relocate(ctx, bp.URI)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(cfg.Lifecycle.URI, dockerScheme):
+		cfg.Lifecycle.URI, err = r.relocate(ctx, cfg.Lifecycle.URI)
+	case cfg.Lifecycle.URI == "" && cfg.Lifecycle.Version != "":
+		// pack downloads lifecycle of given version from GitHub, use the image instead
+		cfg.Lifecycle.URI, err = r.relocate(ctx, dockerScheme+lifecycleImage+":"+cfg.Lifecycle.Version)
+		cfg.Lifecycle.Version = ""
+	}
+	return err
+}
+
+// relocates dependencies of a composite buildpack, images in local exist only in the daemon and are kept as they are
+func (r *relocator) relocatePackageConfig(ctx context.Context, cfg *buildpackage.Config, local []string) error {
+	var err error
+	for i, dep := range cfg.Dependencies {
+		if !strings.HasPrefix(dep.URI, dockerScheme) || slices.Contains(local, strings.TrimPrefix(dep.URI, dockerScheme)) {
+			continue
+		}
+		cfg.Dependencies[i].URI, err = r.relocate(ctx, dep.URI)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
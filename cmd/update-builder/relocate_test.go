@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildpacks/pack/builder"
+	"github.com/buildpacks/pack/pkg/dist"
+)
+
+func TestRelocateBuilderConfig(t *testing.T) {
+	copied := make(map[string]string)
+	r := newRelocator("ghcr.io/gauron99/mirror/")
+	r.copyImage = func(ctx context.Context, srcRef, destRef string) error {
+		copied[srcRef] = destRef
+		return nil
+	}
+
+	cfg := builder.Config{
+		Buildpacks: builder.ModuleCollection{
+			{ImageOrURI: dist.ImageOrURI{BuildpackURI: dist.BuildpackURI{URI: "docker://docker.io/paketocommunity/rust:0.65.0"}}},
+			{ImageOrURI: dist.ImageOrURI{BuildpackURI: dist.BuildpackURI{URI: "docker://gcr.io/paketo-buildpacks/go@sha256:0000000000000000000000000000000000000000000000000000000000000000"}}},
+			{ImageOrURI: dist.ImageOrURI{BuildpackURI: dist.BuildpackURI{URI: "docker://ghcr.io/gauron99/buildpacks/java:1.0.0"}}},
+		},
+		Lifecycle: builder.LifecycleConfig{Version: "0.20.11"},
+	}
+	err := r.relocateBuilderConfig(context.Background(), &cfg, []string{"ghcr.io/gauron99/buildpacks/java:1.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"docker://ghcr.io/gauron99/mirror/docker.io/paketocommunity/rust:0.65.0",
+		"docker://ghcr.io/gauron99/mirror/gcr.io/paketo-buildpacks/go@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+		"docker://ghcr.io/gauron99/buildpacks/java:1.0.0",
+	}
+	for i, w := range want {
+		if cfg.Buildpacks[i].URI != w {
+			t.Errorf("buildpack %d: got %q, want %q", i, cfg.Buildpacks[i].URI, w)
+		}
+	}
+	if cfg.Lifecycle.URI != "docker://ghcr.io/gauron99/mirror/docker.io/buildpacksio/lifecycle:0.20.11" || cfg.Lifecycle.Version != "" {
+		t.Errorf("unexpected lifecycle: %+v", cfg.Lifecycle)
+	}
+
+	if got := copied["gcr.io/paketo-buildpacks/go@sha256:0000000000000000000000000000000000000000000000000000000000000000"]; got != "ghcr.io/gauron99/mirror/gcr.io/paketo-buildpacks/go:sha256-0000000000000000000000000000000000000000000000000000000000000000" {
+		t.Errorf("unexpected copy destination of digest reference: %q", got)
+	}
+	if len(copied) != 3 {
+		t.Errorf("expected 3 copied images, got %v", copied)
+	}
+}
+
+func TestRelocatorDestination(t *testing.T) {
+	r := newRelocator("ghcr.io/gauron99/mirror")
+	tests := map[string]string{
+		"docker.io/paketobuildpacks/java:18.9.0":   "ghcr.io/gauron99/mirror/docker.io/paketobuildpacks/java:18.9.0",
+		"paketobuildpacks/java:18.9.0":             "ghcr.io/gauron99/mirror/docker.io/paketobuildpacks/java:18.9.0",
+		"gcr.io/paketobuildpacks/java:18.9.0":      "ghcr.io/gauron99/mirror/gcr.io/paketobuildpacks/java:18.9.0",
+		"localhost:5000/paketobuildpacks/java:1.0": "ghcr.io/gauron99/mirror/localhost-5000/paketobuildpacks/java:1.0",
+	}
+	for src, want := range tests {
+		got, err := r.destination(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", src, got, want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// buildResult is the manifest of what a run produced, written as JSON
+// to the path given by the -result flag.
+type buildResult struct {
+	Variants map[string]*variantResult `json:"variants"`
+}
+
+type variantResult struct {
+	// Release of the upstream builder.
+	Release string `json:"release,omitempty"`
+	// Published index, e.g. "ghcr.io/gauron99/builder-jammy-base:v0.4.0@sha256:...".
+	Index string `json:"index,omitempty"`
+	// Index in every destination, the first one is Index.
+	Published []string `json:"published,omitempty"`
+	// Resolved versions of injected buildpacks (ID -> version), same for all arches.
+	Buildpacks map[string]string `json:"buildpacks,omitempty"`
+	// Image references pinned to digests (reference -> digest reference).
+	Digests map[string]string `json:"digests,omitempty"`
+	// Images relocated to our registry (reference -> relocated reference).
+	Relocated map[string]string      `json:"relocated,omitempty"`
+	Arches    map[string]*archResult `json:"arches,omitempty"`
+}
+
+type archResult struct {
+	Image string `json:"image,omitempty"`
+}
+
+func (r *buildResult) variant(variant string) *variantResult {
+	if r.Variants == nil {
+		r.Variants = make(map[string]*variantResult)
+	}
+	v, ok := r.Variants[variant]
+	if !ok {
+		v = &variantResult{}
+		r.Variants[variant] = v
+	}
+	return v
+}
+
+func (r *variantResult) arch(arch string) *archResult {
+	if r.Arches == nil {
+		r.Arches = make(map[string]*archResult)
+	}
+	a, ok := r.Arches[arch]
+	if !ok {
+		a = &archResult{}
+		r.Arches[arch] = a
+	}
+	return a
+}
+
+func (r *buildResult) write(path string) error {
+	bs, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal result: %w", err)
+	}
+	err = os.WriteFile(path, append(bs, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write result: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// retryPolicies used by HTTP clients created by the tool, set from configuration by the commands
+var retryPolicies = defaultConfig().Retry
+
+// retryConfig holds retry policy of each type of calls.
+type retryConfig struct {
+	// GitHub API calls.
+	GitHub retryPolicy `json:"github"`
+	// Downloads of release tarballs.
+	Download retryPolicy `json:"download"`
+	// Registry calls made by go-containerregistry.
+	Registry retryPolicy `json:"registry"`
+}
+
+// retryPolicy of exponential backoff with jitter. Delays requested by the server
+// with Retry-After or GitHub X-RateLimit-Reset headers are honoured up to MaxDelay.
+type retryPolicy struct {
+	// Number of attempts including the first one, retries are disabled when it is 1 or less.
+	Attempts int `json:"attempts"`
+	// Delay before the first retry, doubled for each next one up to MaxDelay.
+	InitialDelay duration `json:"initialDelay"`
+	MaxDelay     duration `json:"maxDelay"`
+}
+
+// duration marshaled as Go duration string, e.g. "1m30s"
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(bs []byte) error {
+	var s string
+	err := json.Unmarshal(bs, &s)
+	if err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// returns delay before the retry following the attempt (counted from 1), with jitter
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := time.Duration(p.InitialDelay)
+	for i := 1; i < attempt && d < time.Duration(p.MaxDelay); i++ {
+		d *= 2
+	}
+	d = min(d, time.Duration(p.MaxDelay))
+	if d <= 0 {
+		return 0
+	}
+	// between half and full delay, so that concurrent clients do not retry at once
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryTransport retries requests failing with network errors, server errors and rate limits.
+// Requests that are not idempotent are retried only when rate limited, since a server error or
+// a lost response does not tell whether the server has already created e.g. a release.
+type retryTransport struct {
+	// type of calls, used in logs
+	name   string
+	policy retryPolicy
+	base   http.RoundTripper
+	sleep  func(ctx context.Context, d time.Duration) error
+	now    func() time.Time
+}
+
+func newRetryTransport(name string, policy retryPolicy, base http.RoundTripper) *retryTransport {
+	return &retryTransport{
+		name:   name,
+		policy: policy,
+		base:   base,
+		sleep:  sleepCtx,
+		now:    time.Now,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+
+		delay, retryable := t.retryDelay(resp, err, attempt)
+		if !idempotent(req.Method) && !rateLimited(resp) {
+			retryable = false
+		}
+		// body can be sent again only if it can be recreated
+		if !retryable || attempt >= t.policy.Attempts || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		if delay > time.Duration(t.policy.MaxDelay) {
+			_, _ = fmt.Fprintf(os.Stderr, "not retrying %s %s, server asks to wait %v\n", req.Method, req.URL.Redacted(), delay)
+			return resp, err
+		}
+
+		reason := fmt.Sprint(err)
+		if resp != nil {
+			reason = resp.Status
+			_ = resp.Body.Close()
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "retrying %s call %s %s in %v (attempt %d of %d): %s\n",
+			t.name, req.Method, req.URL.Redacted(), delay.Round(time.Millisecond), attempt+1, t.policy.Attempts, reason)
+		err = t.sleep(req.Context(), delay)
+		if err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// returns whether the request should be retried and the delay before the retry
+func (t *retryTransport) retryDelay(resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if err != nil {
+		return t.policy.backoff(attempt), !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	if s := resp.Header.Get("Retry-After"); s != "" && (resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusServiceUnavailable) {
+		if secs, err := strconv.Atoi(s); err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+		if at, err := http.ParseTime(s); err == nil {
+			return max(at.Sub(t.now()), 0), true
+		}
+	}
+	// GitHub primary rate limit
+	if (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) &&
+		resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return max(time.Unix(reset, 0).Sub(t.now())+time.Second, 0), true
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return t.policy.backoff(attempt), true
+	}
+	return 0, false
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// returns whether the response rejects the request before processing it because of a rate limit,
+// GitHub secondary rate limits are 403 or 429 with Retry-After
+func rateLimited(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0"
+	}
+	return false
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// returns HTTP client for downloads of release tarballs
+func newDownloadClient() *http.Client {
+	return &http.Client{Transport: newRetryTransport("download", retryPolicies.Download, http.DefaultTransport)}
+}
+
+// returns options for go-containerregistry calls, retries are done by the retry transport instead of go-containerregistry
+func registryOptions(ctx context.Context) []remote.Option {
+	return []remote.Option{
+		remote.WithAuthFromKeychain(DefaultKeychain),
+		remote.WithContext(ctx),
+		remote.WithTransport(newRetryTransport("registry", retryPolicies.Registry, remote.DefaultTransport)),
+		remote.WithRetryBackoff(remote.Backoff{Steps: 1}),
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// returns transport of the policy, URL of server calling the handlers in order (the last one repeatedly),
+// delays the transport slept for and number of calls of the server
+func testRetryTransport(t *testing.T, policy retryPolicy, handlers ...http.HandlerFunc) (*retryTransport, string, *[]time.Duration, *int) {
+	t.Helper()
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := handlers[min(calls, len(handlers)-1)]
+		calls++
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	var delays []time.Duration
+	tr := newRetryTransport("test", policy, http.DefaultTransport)
+	tr.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	tr.now = func() time.Time {
+		return time.Unix(1700000000, 0)
+	}
+	return tr, srv.URL, &delays, &calls
+}
+
+func respondWith(code int, header ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i+1 < len(header); i += 2 {
+			w.Header().Set(header[i], header[i+1])
+		}
+		w.WriteHeader(code)
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	policy := retryPolicy{Attempts: 3, InitialDelay: duration(time.Second), MaxDelay: duration(time.Minute)}
+	reset := strconv.FormatInt(time.Unix(1700000000, 0).Add(20*time.Second).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		handlers  []http.HandlerFunc
+		code      int
+		calls     int
+		delays    []time.Duration
+		maxDelays []time.Duration
+	}{
+		{
+			name:      "server error",
+			handlers:  []http.HandlerFunc{respondWith(503), respondWith(200)},
+			code:      200,
+			calls:     2,
+			maxDelays: []time.Duration{time.Second},
+		},
+		{
+			name:     "retry after",
+			handlers: []http.HandlerFunc{respondWith(429, "Retry-After", "7"), respondWith(200)},
+			code:     200,
+			calls:    2,
+			delays:   []time.Duration{7 * time.Second},
+		},
+		{
+			name:     "rate limit reset",
+			handlers: []http.HandlerFunc{respondWith(403, "X-RateLimit-Remaining", "0", "X-RateLimit-Reset", reset), respondWith(200)},
+			code:     200,
+			calls:    2,
+			delays:   []time.Duration{21 * time.Second},
+		},
+		{
+			name:     "server asks to wait too long",
+			handlers: []http.HandlerFunc{respondWith(429, "Retry-After", "3600")},
+			code:     429,
+			calls:    1,
+		},
+		{
+			name:      "attempts exhausted",
+			handlers:  []http.HandlerFunc{respondWith(502)},
+			code:      502,
+			calls:     3,
+			maxDelays: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:     "not found",
+			handlers: []http.HandlerFunc{respondWith(404)},
+			code:     404,
+			calls:    1,
+		},
+		{
+			name:     "forbidden",
+			handlers: []http.HandlerFunc{respondWith(403)},
+			code:     403,
+			calls:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, url, delays, calls := testRetryTransport(t, policy, tt.handlers...)
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.code {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.code)
+			}
+			if *calls != tt.calls {
+				t.Errorf("calls = %d, want %d", *calls, tt.calls)
+			}
+			if tt.delays != nil && !slices.Equal(*delays, tt.delays) {
+				t.Errorf("delays = %v, want %v", *delays, tt.delays)
+			}
+			if tt.maxDelays != nil {
+				if len(*delays) != len(tt.maxDelays) {
+					t.Fatalf("delays = %v, want %d of them", *delays, len(tt.maxDelays))
+				}
+				for i, d := range *delays {
+					if d < tt.maxDelays[i]/2 || d > tt.maxDelays[i] {
+						t.Errorf("delay %d = %v, want between %v and %v", i, d, tt.maxDelays[i]/2, tt.maxDelays[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRetryTransportResendsBody(t *testing.T) {
+	var bodies []string
+	record := func(code int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			bs, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(bs))
+			w.WriteHeader(code)
+		}
+	}
+	tr, url, _, _ := testRetryTransport(t, retryPolicy{Attempts: 2}, record(500), record(201))
+
+	req, err := http.NewRequest(http.MethodPut, url, strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Errorf("status = %d, want 201", resp.StatusCode)
+	}
+	if !slices.Equal(bodies, []string{"payload", "payload"}) {
+		t.Errorf("bodies = %q", bodies)
+	}
+}
+
+func TestRetryTransportNonIdempotent(t *testing.T) {
+	policy := retryPolicy{Attempts: 3, MaxDelay: duration(time.Minute)}
+	tests := []struct {
+		name     string
+		handlers []http.HandlerFunc
+		calls    int
+	}{
+		{name: "server error", handlers: []http.HandlerFunc{respondWith(502), respondWith(201)}, calls: 1},
+		{name: "rate limit", handlers: []http.HandlerFunc{respondWith(429), respondWith(201)}, calls: 2},
+		{name: "secondary rate limit", handlers: []http.HandlerFunc{respondWith(403, "Retry-After", "1"), respondWith(201)}, calls: 2},
+		{name: "forbidden", handlers: []http.HandlerFunc{respondWith(403), respondWith(201)}, calls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, url, _, calls := testRetryTransport(t, policy, tt.handlers...)
+			req, err := http.NewRequest(http.MethodPost, url, strings.NewReader("release"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if *calls != tt.calls {
+				t.Errorf("calls = %d, want %d", *calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyJSON(t *testing.T) {
+	var p retryPolicy
+	err := json.Unmarshal([]byte(`{"attempts": 2, "initialDelay": "500ms", "maxDelay": "1m30s"}`), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := retryPolicy{Attempts: 2, InitialDelay: duration(500 * time.Millisecond), MaxDelay: duration(90 * time.Second)}
+	if p != want {
+		t.Errorf("policy = %+v, want %+v", p, want)
+	}
+	bs, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != `{"attempts":2,"initialDelay":"500ms","maxDelay":"1m30s"}` {
+		t.Errorf("marshaled = %s", bs)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Signatures are stored the same way cosign stores them, so they can be verified by
+// "cosign verify --key" as well: the simple signing payload is a layer of an image
+// tagged "sha256-<hex>.sig" in the repository of the signed image, the signature is
+// in the annotation of the layer.
+const (
+	simpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	signatureAnnotation    = "dev.cosignproject.cosign/signature"
+	signatureType          = "cosign container image signature"
+)
+
+type simpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]string `json:"optional"`
+}
+
+// environment variable holding password of encrypted cosign keys, the same cosign reads
+const cosignPasswordEnv = "COSIGN_PASSWORD"
+
+// loads ECDSA private key from PEM file (PKCS #8 or SEC 1), or encrypted key written by
+// "cosign generate-key-pair" decrypted with password from COSIGN_PASSWORD
+func loadSigningKey(path string) (*ecdsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "ENCRYPTED SIGSTORE PRIVATE KEY", "ENCRYPTED COSIGN PRIVATE KEY":
+		var der []byte
+		der, err = decryptCosignKey(block.Bytes, []byte(os.Getenv(cosignPasswordEnv)))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt cosign key with password from %s: %w", cosignPasswordEnv, err)
+		}
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q, expected PKCS #8, SEC 1 or encrypted cosign key", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key: %w", err)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an ECDSA key")
+	}
+	return ecKey, nil
+}
+
+// cosignEncryptedKey is the encrypted private key of cosign, scrypt derives key of nacl secretbox from the password.
+type cosignEncryptedKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// returns PKCS #8 DER of the encrypted cosign key
+func decryptCosignKey(bs, password []byte) ([]byte, error) {
+	var ek cosignEncryptedKey
+	err := json.Unmarshal(bs, &ek)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse encrypted key: %w", err)
+	}
+	if ek.KDF.Name != "scrypt" || ek.Cipher.Name != "nacl/secretbox" || len(ek.Cipher.Nonce) != 24 {
+		return nil, fmt.Errorf("unsupported encryption %s with %s", ek.KDF.Name, ek.Cipher.Name)
+	}
+	secret, err := scrypt.Key(password, ek.KDF.Salt, ek.KDF.Params.N, ek.KDF.Params.R, ek.KDF.Params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	var k [32]byte
+	copy(nonce[:], ek.Cipher.Nonce)
+	copy(k[:], secret)
+	der, ok := secretbox.Open(nil, ek.Ciphertext, &nonce, &k)
+	if !ok {
+		return nil, errors.New("wrong password")
+	}
+	return der, nil
+}
+
+// loads ECDSA public key from PEM file
+func loadVerificationKey(path string) (*ecdsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse public key: %w", err)
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not an ECDSA key")
+	}
+	return ecKey, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key: %w", err)
+	}
+	block, _ := pem.Decode(bs)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %q", path)
+	}
+	return block, nil
+}
+
+// reference of the signature image of the image with given digest
+func signatureTag(repo name.Repository, digest v1.Hash) name.Tag {
+	return repo.Tag(digest.Algorithm + "-" + digest.Hex + ".sig")
+}
+
+// signs the index and all its manifests, signatures are pushed to the repository of the index
+func signIndex(key *ecdsa.PrivateKey, repo name.Repository, idx v1.ImageIndex, remoteOpts ...remote.Option) error {
+	digest, err := idx.Digest()
+	if err != nil {
+		return fmt.Errorf("cannot get digest of the index: %w", err)
+	}
+	err = signDigest(key, repo, digest, remoteOpts...)
+	if err != nil {
+		return err
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("cannot get index manifest: %w", err)
+	}
+	for _, desc := range im.Manifests {
+		err = signDigest(key, repo, desc.Digest, remoteOpts...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signs the manifest with given digest, existing signatures are kept
+func signDigest(key *ecdsa.PrivateKey, repo name.Repository, digest v1.Hash, remoteOpts ...remote.Option) error {
+	var payload simpleSigning
+	payload.Critical.Identity.DockerReference = repo.Name()
+	payload.Critical.Image.DockerManifestDigest = digest.String()
+	payload.Critical.Type = signatureType
+	bs, err := json.Marshal(&payload)
+	if err != nil {
+		return fmt.Errorf("cannot marshal signature payload: %w", err)
+	}
+
+	h := sha256.Sum256(bs)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, h[:])
+	if err != nil {
+		return fmt.Errorf("cannot sign %s: %w", digest, err)
+	}
+
+	sigRef := signatureTag(repo, digest)
+	sigImg, err := remote.Image(sigRef, remoteOpts...)
+	if err != nil {
+		if !isNotFound(err) {
+			return fmt.Errorf("cannot get existing signatures: %w", err)
+		}
+		sigImg = mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+		sigImg = mutate.ConfigMediaType(sigImg, types.OCIConfigJSON)
+	}
+	sigImg, err = mutate.Append(sigImg, mutate.Addendum{
+		Layer: static.NewLayer(bs, simpleSigningMediaType),
+		Annotations: map[string]string{
+			signatureAnnotation: base64.StdEncoding.EncodeToString(sig),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot append signature: %w", err)
+	}
+	err = remote.Write(sigRef, sigImg, remoteOpts...)
+	if err != nil {
+		return fmt.Errorf("cannot push signature: %w", err)
+	}
+	fmt.Printf("## signed: '%v@%v' -> '%v'\n", repo.Name(), digest, sigRef)
+	return nil
+}
+
+// verifies signatures of the image or index and, for an index, of all its manifests
+func verifySignatures(key *ecdsa.PublicKey, ref name.Reference, remoteOpts ...remote.Option) error {
+	desc, err := remote.Get(ref, remoteOpts...)
+	if err != nil {
+		return fmt.Errorf("cannot get %q: %w", ref, err)
+	}
+	repo := ref.Context()
+	err = verifyDigest(key, repo, desc.Digest, remoteOpts...)
+	if err != nil {
+		return err
+	}
+	if !desc.MediaType.IsIndex() {
+		return nil
+	}
+	idx, err := desc.ImageIndex()
+	if err != nil {
+		return fmt.Errorf("cannot get index: %w", err)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("cannot get index manifest: %w", err)
+	}
+	var errs []error
+	for _, d := range im.Manifests {
+		errs = append(errs, verifyDigest(key, repo, d.Digest, remoteOpts...))
+	}
+	return errors.Join(errs...)
+}
+
+// checks that at least one signature of the manifest with given digest is valid
+func verifyDigest(key *ecdsa.PublicKey, repo name.Repository, digest v1.Hash, remoteOpts ...remote.Option) error {
+	sigImg, err := remote.Image(signatureTag(repo, digest), remoteOpts...)
+	if err != nil {
+		if isNotFound(err) {
+			return fmt.Errorf("%s is not signed", digest)
+		}
+		return fmt.Errorf("cannot get signatures of %s: %w", digest, err)
+	}
+	m, err := sigImg.Manifest()
+	if err != nil {
+		return fmt.Errorf("cannot get signature manifest: %w", err)
+	}
+	for _, l := range m.Layers {
+		if l.MediaType != simpleSigningMediaType {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(l.Annotations[signatureAnnotation])
+		if err != nil {
+			continue
+		}
+		layer, err := sigImg.LayerByDigest(l.Digest)
+		if err != nil {
+			return fmt.Errorf("cannot get signature payload: %w", err)
+		}
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return fmt.Errorf("cannot read signature payload: %w", err)
+		}
+		payload, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			return fmt.Errorf("cannot read signature payload: %w", err)
+		}
+		h := sha256.Sum256(payload)
+		if !ecdsa.VerifyASN1(key, h[:], sig) {
+			continue
+		}
+		var ss simpleSigning
+		if json.Unmarshal(payload, &ss) != nil {
+			continue
+		}
+		if ss.Critical.Image.DockerManifestDigest == digest.String() &&
+			strings.TrimPrefix(ss.Critical.Identity.DockerReference, "index.docker.io/") == strings.TrimPrefix(repo.Name(), "index.docker.io/") {
+			fmt.Printf("## verified signature of %s\n", digest)
+			return nil
+		}
+	}
+	return fmt.Errorf("no valid signature of %s", digest)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+func writeKeys(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	privPath, pubPath := filepath.Join(dir, "cosign.key"), filepath.Join(dir, "cosign.pub")
+	err = os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priv}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privPath, pubPath
+}
+
+func TestSignAndVerifyIndex(t *testing.T) {
+	idx, err := random.Index(64, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := pushTestIndex(t, testRegistry(t)+"/builder-jammy-base:0.0.1", idx)
+
+	privPath, pubPath := writeKeys(t)
+	_, otherPubPath := writeKeys(t)
+	priv, err := loadSigningKey(privPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := loadVerificationKey(pubPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, err := loadVerificationKey(otherPubPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = verifySignatures(pub, ref); err == nil {
+		t.Fatal("expected error for unsigned index")
+	}
+
+	if err = signIndex(priv, ref.Context(), idx); err != nil {
+		t.Fatal(err)
+	}
+	if err = verifySignatures(pub, ref); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = verifySignatures(otherPub, ref); err == nil {
+		t.Error("expected error for signatures made by other key")
+	}
+
+	// signing again keeps the previous signature
+	if err = signIndex(priv, ref.Context(), idx); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := idx.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigImg, err := remote.Image(signatureTag(ref.Context(), digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := sigImg.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers) != 2 {
+		t.Errorf("expected 2 signatures, got %d", len(layers))
+	}
+}
+
+func TestLoadEncryptedCosignKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// encrypted the way "cosign generate-key-pair" does, with cheaper scrypt parameters
+	var ek cosignEncryptedKey
+	ek.KDF.Name, ek.Cipher.Name = "scrypt", "nacl/secretbox"
+	ek.KDF.Params.N, ek.KDF.Params.R, ek.KDF.Params.P = 1024, 8, 1
+	ek.KDF.Salt, ek.Cipher.Nonce = make([]byte, 32), make([]byte, 24)
+	_, _ = rand.Read(ek.KDF.Salt)
+	_, _ = rand.Read(ek.Cipher.Nonce)
+	secret, err := scrypt.Key([]byte("s3cret"), ek.KDF.Salt, 1024, 8, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nonce [24]byte
+	var k [32]byte
+	copy(nonce[:], ek.Cipher.Nonce)
+	copy(k[:], secret)
+	ek.Ciphertext = secretbox.Seal(nil, der, &nonce, &k)
+	bs, err := json.Marshal(ek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cosign.key")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED SIGSTORE PRIVATE KEY", Bytes: bs}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(cosignPasswordEnv, "s3cret")
+	loaded, err := loadSigningKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Equal(key) {
+		t.Error("decrypted key differs")
+	}
+
+	t.Setenv(cosignPasswordEnv, "wrong")
+	_, err = loadSigningKey(path)
+	if err == nil || !strings.Contains(err.Error(), "wrong password") {
+		t.Errorf("expected wrong password error, got: %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/buildpacks/pack/builder"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-github/v68/github"
+)
+
+// Strategies of versionSpec.
+const (
+	strategyExact    = "exact"
+	strategyLatest   = "latest"
+	strategyRange    = "range"
+	strategyUpstream = "upstream"
+)
+
+// versionSpec describes how the version of an injected buildpack is chosen.
+//
+// Versions of latest and range strategies are looked up either in releases
+// of the GitHub repository or in tags of the image repository, exactly one
+// of them has to be set. The upstream strategy uses the version of the same
+// buildpack referenced by the upstream builder.
+type versionSpec struct {
+	Strategy string `json:"strategy"`
+	// Version for the exact strategy.
+	Version string `json:"version,omitempty"`
+	// Semver constraint for the range strategy, e.g. "~0.65".
+	Range string `json:"range,omitempty"`
+	// GitHub repository (owner/repo) with releases tagged "v<version>".
+	GitHub string `json:"github,omitempty"`
+	// Image repository with tags "<version>", e.g. "docker.io/paketocommunity/rust".
+	Image string `json:"image,omitempty"`
+}
+
+type versionResolver struct {
+	ghClient   *github.Client
+	remoteOpts []remote.Option
+	// config of the upstream builder used by the upstream strategy
+	upstream *builder.Config
+}
+
+func (r *versionResolver) resolve(ctx context.Context, id string, spec versionSpec) (string, error) {
+	switch spec.Strategy {
+	case strategyExact:
+		if spec.Version == "" {
+			return "", fmt.Errorf("version not set for %q", id)
+		}
+		return spec.Version, nil
+	case strategyLatest, strategyRange:
+		if spec.Strategy == strategyLatest && spec.GitHub != "" && spec.Image == "" {
+			v, err := r.latestRelease(ctx, spec.GitHub)
+			if err != nil {
+				return "", fmt.Errorf("cannot get latest version of %q: %w", id, err)
+			}
+			return v, nil
+		}
+		var constraint *semver.Constraints
+		if spec.Strategy == strategyRange {
+			var err error
+			constraint, err = semver.NewConstraint(spec.Range)
+			if err != nil {
+				return "", fmt.Errorf("invalid range of %q: %w", id, err)
+			}
+		}
+		var (
+			candidates []string
+			err        error
+		)
+		switch {
+		case spec.GitHub != "" && spec.Image == "":
+			candidates, err = r.releaseVersions(ctx, spec.GitHub)
+		case spec.Image != "" && spec.GitHub == "":
+			candidates, err = r.tagVersions(ctx, spec.Image)
+		default:
+			return "", fmt.Errorf("exactly one of github or image has to be set for %q", id)
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot list versions of %q: %w", id, err)
+		}
+		v, ok := newestVersion(candidates, constraint)
+		if !ok {
+			return "", fmt.Errorf("no version of %q matches %q", id, spec.Range)
+		}
+		return v, nil
+	case strategyUpstream:
+		if r.upstream == nil {
+			return "", fmt.Errorf("upstream builder not known")
+		}
+		for _, bp := range r.upstream.Buildpacks {
+			if bp.ID == id && bp.Version != "" {
+				return bp.Version, nil
+			}
+		}
+		for _, entry := range r.upstream.Order {
+			for _, ref := range entry.Group {
+				if ref.ID == id && ref.Version != "" {
+					return ref.Version, nil
+				}
+			}
+		}
+		return "", fmt.Errorf("upstream builder does not contain %q", id)
+	default:
+		return "", fmt.Errorf("unknown version strategy %q for %q", spec.Strategy, id)
+	}
+}
+
+func (r *versionResolver) latestRelease(ctx context.Context, repository string) (string, error) {
+	owner, repo, ok := strings.Cut(repository, "/")
+	if !ok {
+		return "", fmt.Errorf("invalid repository %q", repository)
+	}
+	rr, resp, err := r.ghClient.Repositories.GetLatestRelease(ctx, owner, repo)
+	if err != nil {
+		return "", fmt.Errorf("cannot get latest release: %w", err)
+	}
+	_ = resp.Body.Close()
+	if rr.TagName == nil {
+		return "", fmt.Errorf("tag name is nil")
+	}
+	return strings.TrimPrefix(rr.GetTagName(), "v"), nil
+}
+
+// lists versions of non-draft, non-prerelease releases in the GitHub repository
+func (r *versionResolver) releaseVersions(ctx context.Context, repository string) ([]string, error) {
+	owner, repo, ok := strings.Cut(repository, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid repository %q", repository)
+	}
+
+	var versions []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		releases, resp, err := r.ghClient.Repositories.ListReleases(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list releases: %w", err)
+		}
+		_ = resp.Body.Close()
+		for _, rr := range releases {
+			if rr.GetDraft() || rr.GetPrerelease() {
+				continue
+			}
+			versions = append(versions, strings.TrimPrefix(rr.GetTagName(), "v"))
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return versions, nil
+}
+
+func (r *versionResolver) tagVersions(ctx context.Context, image string) ([]string, error) {
+	repo, err := name.NewRepository(image)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse repository: %w", err)
+	}
+	tags, err := remote.List(repo, append(slices.Clone(r.remoteOpts), remote.WithContext(ctx))...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list tags: %w", err)
+	}
+	return tags, nil
+}
+
+// returns the newest of the semver versions satisfying the constraint, non-semver versions and pre-releases are ignored
+func newestVersion(versions []string, constraint *semver.Constraints) (string, bool) {
+	var parsed []*semver.Version
+	for _, v := range versions {
+		sv, err := semver.StrictNewVersion(v)
+		if err != nil || sv.Prerelease() != "" {
+			continue
+		}
+		if constraint != nil && !constraint.Check(sv) {
+			continue
+		}
+		parsed = append(parsed, sv)
+	}
+	if len(parsed) == 0 {
+		return "", false
+	}
+	return slices.MaxFunc(parsed, func(a, b *semver.Version) int {
+		return a.Compare(b)
+	}).Original(), true
+}
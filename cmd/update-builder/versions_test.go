@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/buildpacks/pack/builder"
+	"github.com/buildpacks/pack/pkg/dist"
+)
+
+func TestNewestVersion(t *testing.T) {
+	tags := []string{"latest", "0.64.0", "0.65.0", "0.65.2", "0.66.0-rc.1", "0.66.0", "1.0"}
+
+	v, ok := newestVersion(tags, nil)
+	if !ok || v != "0.66.0" {
+		t.Errorf("latest: got %q, %v", v, ok)
+	}
+
+	c, err := semver.NewConstraint("~0.65")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, ok = newestVersion(tags, c)
+	if !ok || v != "0.65.2" {
+		t.Errorf("range: got %q, %v", v, ok)
+	}
+
+	c, err = semver.NewConstraint(">=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok = newestVersion(tags, c); ok {
+		t.Errorf("expected no match, got %q", v)
+	}
+}
+
+func TestResolveExactAndUpstream(t *testing.T) {
+	r := &versionResolver{
+		upstream: &builder.Config{
+			Buildpacks: builder.ModuleCollection{
+				{ModuleInfo: dist.ModuleInfo{ID: "paketo-buildpacks/java", Version: "18.9.0"}},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	v, err := r.resolve(ctx, "paketo-community/rust", versionSpec{Strategy: strategyExact, Version: "0.65.0"})
+	if err != nil || v != "0.65.0" {
+		t.Errorf("exact: got %q, %v", v, err)
+	}
+
+	v, err = r.resolve(ctx, "paketo-buildpacks/java", versionSpec{Strategy: strategyUpstream})
+	if err != nil || v != "18.9.0" {
+		t.Errorf("upstream: got %q, %v", v, err)
+	}
+
+	if _, err = r.resolve(ctx, "paketo-buildpacks/quarkus", versionSpec{Strategy: strategyUpstream}); err == nil {
+		t.Error("expected error for buildpack missing in upstream builder")
+	}
+	if _, err = r.resolve(ctx, "paketo-buildpacks/quarkus", versionSpec{Strategy: "newest"}); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
+
+func TestTagVersionsCancelled(t *testing.T) {
+	reg := testRegistry(t)
+	r := &versionResolver{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := r.tagVersions(ctx, reg+"/paketocommunity/rust")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation, got: %v", err)
+	}
+}